@@ -0,0 +1,436 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/maintenance"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+const errAccountLocked = "this account has been locked by an administrator"
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type AdminHandler struct {
+	adminStore       db.AdminStore
+	userStore        db.UserStore
+	taskStore        db.TaskStore
+	preferencesStore db.PreferencesStore
+	maintenance      *maintenance.Runner
+	securityLog      *SecurityLog
+	logger           *log.Logger
+}
+
+type adminUserResponse struct {
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Activated bool       `json:"activated"`
+	Role      string     `json:"role"`
+	LockedAt  *time.Time `json:"locked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func NewAdminHandler(adminStore db.AdminStore, userStore db.UserStore, taskStore db.TaskStore, preferencesStore db.PreferencesStore, maintenance *maintenance.Runner, securityLog *SecurityLog, logger *log.Logger) *AdminHandler {
+	return &AdminHandler{
+		adminStore:       adminStore,
+		userStore:        userStore,
+		taskStore:        taskStore,
+		preferencesStore: preferencesStore,
+		maintenance:      maintenance,
+		securityLog:      securityLog,
+		logger:           logger,
+	}
+}
+
+// HandleListUsers lists users, optionally filtered by a search on username or
+// email.
+func (ah *AdminHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleListUsers"
+
+	limit, offset, ok := readPage(w, r)
+	if !ok {
+		return
+	}
+
+	search := r.URL.Query().Get("search")
+	err := ah.adminStore.RecordAction(r.Context(), ah.action(r, db.AdminActionListUsers, 0, map[string]any{
+		"search": search,
+		"limit":  limit,
+		"offset": offset,
+	}))
+	if err != nil {
+		ah.logger.Printf("Error in %s: Recording action - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not list users"})
+		return
+	}
+
+	users, total, err := ah.adminStore.ListUsers(r.Context(), search, limit, offset)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Listing users - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not list users"})
+		return
+	}
+
+	response := make([]adminUserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newAdminUserResponse(user))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"users": response, "total": total})
+}
+
+// HandleGetUser shows one user with their task counts.
+func (ah *AdminHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleGetUser"
+
+	userID, ok := readUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := ah.adminStore.RecordAction(r.Context(), ah.action(r, db.AdminActionViewUser, userID, nil))
+	if err != nil {
+		ah.logger.Printf("Error in %s: Recording action - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	user, err := ah.userStore.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("Error in %s: Getting user by ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	// Counted in the user's time zone, so the admin sees what the user sees
+	loc, err := userLocation(r.Context(), ah.preferencesStore, user.ID)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Getting time zone - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	counts, err := ah.taskStore.CountTasksForUser(r.Context(), user.ID, time.Now().In(loc))
+	if err != nil {
+		ah.logger.Printf("Error in %s: Counting tasks - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"user":  newAdminUserResponse(user),
+		"tasks": counts,
+	})
+}
+
+// HandleLockUser locks an account and signs the user out everywhere.
+func (ah *AdminHandler) HandleLockUser(w http.ResponseWriter, r *http.Request) {
+	ah.setLocked(w, r, true)
+}
+
+func (ah *AdminHandler) HandleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	ah.setLocked(w, r, false)
+}
+
+func (ah *AdminHandler) setLocked(w http.ResponseWriter, r *http.Request, locked bool) {
+	funcName, action := "HandleUnlockUser", db.AdminActionUnlockUser
+	if locked {
+		funcName, action = "HandleLockUser", db.AdminActionLockUser
+	}
+
+	userID, ok := readUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if locked && userID == int64(middleware.GetUser(r).ID) {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "you cannot lock your own account"})
+		return
+	}
+
+	err := ah.adminStore.SetUserLocked(r.Context(), userID, locked, ah.action(r, action, userID, nil))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("Error in %s: Setting locked - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not update user"})
+		return
+	}
+
+	if locked {
+		ah.securityLog.Record(r, int(userID), db.SecurityEventTokenRevoked, map[string]any{
+			"kind":   "all",
+			"reason": "account_locked",
+		})
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"locked": locked})
+}
+
+func (ah *AdminHandler) HandleSetRole(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleSetRole"
+
+	userID, ok := readUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var input setRoleRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if input.Role != db.RoleUser && input.Role != db.RoleAdmin {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"role": "role must be user or admin",
+		}})
+		return
+	}
+
+	// Keeps the last admin from locking everyone out of the admin API
+	if userID == int64(middleware.GetUser(r).ID) {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "you cannot change your own role"})
+		return
+	}
+
+	err = ah.adminStore.SetUserRole(r.Context(), userID, input.Role, ah.action(r, db.AdminActionSetRole, userID, map[string]any{
+		"role": input.Role,
+	}))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("Error in %s: Setting role - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not update user"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"role": input.Role})
+}
+
+// HandleRevokeTokens signs the user out everywhere and revokes their API keys
+// without locking the account.
+func (ah *AdminHandler) HandleRevokeTokens(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleRevokeTokens"
+
+	userID, ok := readUserID(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := ah.adminStore.RevokeUserTokens(r.Context(), userID, ah.action(r, db.AdminActionRevokeTokens, userID, nil))
+	if err != nil {
+		ah.logger.Printf("Error in %s: Revoking tokens - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not revoke tokens"})
+		return
+	}
+
+	ah.securityLog.Record(r, int(userID), db.SecurityEventTokenRevoked, map[string]any{
+		"kind":    "all",
+		"reason":  "admin",
+		"revoked": revoked,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"revoked": revoked})
+}
+
+// HandleListAuditLog lists admin actions, newest first, optionally only those
+// concerning one user.
+func (ah *AdminHandler) HandleListAuditLog(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleListAuditLog"
+
+	limit, offset, ok := readPage(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := readUserIDFilter(w, r)
+	if !ok {
+		return
+	}
+
+	err := ah.adminStore.RecordAction(r.Context(), ah.action(r, db.AdminActionViewAuditLog, userID, nil))
+	if err != nil {
+		ah.logger.Printf("Error in %s: Recording action - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve audit log"})
+		return
+	}
+
+	entries, err := ah.adminStore.ListActions(r.Context(), userID, limit, offset)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Listing actions - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve audit log"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"entries": entries})
+}
+
+// HandleListSecurityEvents lists security events across all users, newest
+// first, optionally only one user's or one kind.
+func (ah *AdminHandler) HandleListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleListSecurityEvents"
+
+	limit, offset, ok := readPage(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := readUserIDFilter(w, r)
+	if !ok {
+		return
+	}
+
+	event := r.URL.Query().Get("event")
+	err := ah.adminStore.RecordAction(r.Context(), ah.action(r, db.AdminActionViewSecurityLog, userID, map[string]any{
+		"event": event,
+	}))
+	if err != nil {
+		ah.logger.Printf("Error in %s: Recording action - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve security events"})
+		return
+	}
+
+	events, err := ah.securityLog.Store.ListEvents(r.Context(), userID, event, limit, offset)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Listing events - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve security events"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"events": events})
+}
+
+// HandleGetMaintenance reports what the cleanup jobs on this instance have
+// removed since it started. Runs on other instances are not included.
+func (ah *AdminHandler) HandleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleGetMaintenance"
+
+	err := ah.adminStore.RecordAction(r.Context(), ah.action(r, db.AdminActionViewMaintenance, 0, nil))
+	if err != nil {
+		ah.logger.Printf("Error in %s: Recording action - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve maintenance stats"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"jobs": ah.maintenance.Stats()})
+}
+
+// action builds the audit entry for the request. A targetUserID of 0 means the
+// action concerns no particular user.
+func (ah *AdminHandler) action(r *http.Request, name string, targetUserID int64, details map[string]any) *db.AdminAction {
+	action := &db.AdminAction{
+		ActorID: middleware.GetUser(r).ID,
+		Action:  name,
+		Details: details,
+		IP:      utils.ClientIP(r),
+	}
+
+	if targetUserID != 0 {
+		target := int(targetUserID)
+		action.TargetUserID = &target
+	}
+
+	return action
+}
+
+func newAdminUserResponse(user *db.User) adminUserResponse {
+	return adminUserResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Activated: user.Activated,
+		Role:      user.Role,
+		LockedAt:  user.LockedAt,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+}
+
+func readUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// readUserIDFilter reads the optional user_id query parameter, returning 0 if
+// it is absent. It writes the error response itself and returns false if it is
+// invalid.
+func readUserIDFilter(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	value := r.URL.Query().Get("user_id")
+	if value == "" {
+		return 0, true
+	}
+
+	userID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || userID < 1 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"user_id": "user_id must be a positive integer",
+		}})
+		return 0, false
+	}
+	return userID, true
+}
+
+// readPage reads the limit and offset query parameters. It writes the error
+// response itself and returns false if either is invalid.
+func readPage(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	query := r.URL.Query()
+	limit, offset := defaultPageSize, 0
+	var err error
+
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+				"limit": "limit must be between 1 and " + strconv.Itoa(maxPageSize),
+			}})
+			return 0, 0, false
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+				"offset": "offset must be zero or more",
+			}})
+			return 0, 0, false
+		}
+	}
+
+	return limit, offset, true
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/tokens"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+type APIKeyHandler struct {
+	apiKeyStore db.APIKeyStore
+	securityLog *SecurityLog
+	logger      *log.Logger
+}
+
+type APIKeyRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Expiry      string   `json:"expiry"`
+}
+
+func NewAPIKeyHandler(apiKeyStore db.APIKeyStore, securityLog *SecurityLog, logger *log.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyStore: apiKeyStore,
+		securityLog: securityLog,
+		logger:      logger,
+	}
+}
+
+func (ah *APIKeyHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleCreateAPIKey"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	var input APIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	validationErrors := validateAPIKeyInput(input)
+	if len(validationErrors) > 0 {
+		ah.logger.Printf("Error in %s: Validating input - %+v", funcName, validationErrors)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": validationErrors})
+		return
+	}
+
+	var expiry *time.Time
+	if input.Expiry != "" {
+		parsed, _ := time.Parse(time.RFC3339, input.Expiry)
+		expiry = &parsed
+	}
+
+	plaintext, prefix, hash, err := tokens.GenerateAPIKey()
+	if err != nil {
+		ah.logger.Printf("Error in %s: Generating api key - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create api key"})
+		return
+	}
+
+	key := &db.APIKey{
+		UserID:      user.ID,
+		Name:        strings.TrimSpace(input.Name),
+		Prefix:      prefix,
+		Hash:        hash,
+		Permissions: input.Permissions,
+		Expiry:      expiry,
+	}
+
+	err = ah.apiKeyStore.CreateAPIKey(r.Context(), key)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Creating api key - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create api key"})
+		return
+	}
+
+	ah.securityLog.Record(r, user.ID, db.SecurityEventTokenIssued, map[string]any{
+		"kind":        "api_key",
+		"api_key_id":  key.ID,
+		"name":        key.Name,
+		"permissions": key.Permissions,
+	})
+
+	// The plaintext key is only ever shown in this response
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
+		"api_key": key,
+		"key":     plaintext,
+	})
+}
+
+func (ah *APIKeyHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleListAPIKeys"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	keys, err := ah.apiKeyStore.ListAPIKeysForUser(r.Context(), user.ID)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Listing api keys - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve api keys"})
+		return
+	}
+
+	if keys == nil {
+		keys = []*db.APIKey{}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"api_keys": keys})
+}
+
+func (ah *APIKeyHandler) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleDeleteAPIKey"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	keyID, err := utils.ReadIDParam(r)
+	if err != nil {
+		ah.logger.Printf("Error in %s: Reading api key ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid api key ID"})
+		return
+	}
+
+	err = ah.apiKeyStore.DeleteAPIKey(r.Context(), keyID, user.ID)
+	if errors.Is(err, db.ErrAPIKeyNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "api key not found"})
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("Error in %s: Deleting api key %d - %v", funcName, keyID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to delete api key"})
+		return
+	}
+
+	ah.securityLog.Record(r, user.ID, db.SecurityEventTokenRevoked, map[string]any{
+		"kind":       "api_key",
+		"api_key_id": keyID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateAPIKeyInput(input APIKeyRequest) map[string]string {
+	errors := make(map[string]string)
+
+	if strings.TrimSpace(input.Name) == "" {
+		errors["name"] = "name is required"
+	} else if len(input.Name) > 100 {
+		errors["name"] = "name must be less than 100 characters"
+	}
+
+	if len(input.Permissions) == 0 {
+		errors["permissions"] = "at least one permission is required"
+	}
+	for _, permission := range input.Permissions {
+		if !slices.Contains(tokens.APIKeyPermissions, permission) {
+			errors["permissions"] = "permissions must be any of " + strings.Join(tokens.APIKeyPermissions, ", ")
+			break
+		}
+	}
+
+	if input.Expiry != "" {
+		parsed, err := time.Parse(time.RFC3339, input.Expiry)
+		if err != nil {
+			errors["expiry"] = "expiry must be in RFC3339 format (e.g., 2025-05-17T15:04:05Z)"
+		} else if !parsed.After(time.Now()) {
+			errors["expiry"] = "expiry must be in the future"
+		}
+	}
+
+	return errors
+}
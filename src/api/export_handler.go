@@ -0,0 +1,146 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/export"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/tokens"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+// exportWriteTimeout replaces the server's write timeout for exports, which
+// can take longer than an ordinary response for users with many tasks.
+const exportWriteTimeout = 5 * time.Minute
+
+// HandleExportUser streams a ZIP of the user's profile, tasks and sessions.
+// See the export package for the archive layout.
+func (uh *UserHandler) HandleExportUser(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleExportUser"
+
+	authUser := middleware.GetUser(r)
+	if authUser == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	user, err := uh.userStore.GetUserByID(r.Context(), int64(authUser.ID))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Getting user by ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to export data"})
+		return
+	}
+
+	enrollment, err := uh.twoFactorStore.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Getting totp - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to export data"})
+		return
+	}
+
+	sessions, err := uh.tokenStore.ListSessionsForUser(r.Context(), int64(user.ID))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Listing sessions - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to export data"})
+		return
+	}
+
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Extending write deadline - %v", funcName, err)
+	}
+
+	now := time.Now().UTC()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="moving-checklist-export-%s.zip"`, now.Format("20060102")))
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so a failure part way through can only be
+	// signalled by dropping the connection rather than finishing a valid but
+	// incomplete archive
+	err = uh.writeExport(r, export.NewArchive(w), user, enrollment.Enabled(), sessions, now)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Writing archive - %v", funcName, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (uh *UserHandler) writeExport(r *http.Request, archive *export.Archive, user *db.User, twoFactorEnabled bool, sessions []*tokens.Token, now time.Time) error {
+	err := archive.WriteFile(export.ProfileFile, export.Profile{
+		ID:               user.ID,
+		Username:         user.Username,
+		Email:            user.Email,
+		Activated:        user.Activated,
+		TwoFactorEnabled: twoFactorEnabled,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	tasks, err := archive.CreateArray(export.TasksFile)
+	if err != nil {
+		return err
+	}
+
+	err = uh.taskStore.EachTaskForUser(r.Context(), user.ID, func(task *db.Task) error {
+		return tasks.Write(export.Task{
+			ID:          task.ID,
+			Name:        task.Name,
+			Description: task.Description,
+			Category:    task.Category,
+			IsComplete:  task.IsComplete,
+			DueDate:     nullTimePtr(task.DueDate),
+			DueOn:       task.DueOn,
+			CreatedAt:   nullTimePtr(task.CreatedAt),
+			UpdatedAt:   nullTimePtr(task.UpdatedAt),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tasks.Close()
+	if err != nil {
+		return err
+	}
+
+	sessionList, err := archive.CreateArray(export.SessionsFile)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		err = sessionList.Write(export.Session{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Expiry:     session.Expiry,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = sessionList.Close()
+	if err != nil {
+		return err
+	}
+
+	return archive.Close(now)
+}
+
+func nullTimePtr(nt sql.NullTime) *time.Time {
+	if !nt.Valid {
+		return nil
+	}
+	return &nt.Time
+}
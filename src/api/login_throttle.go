@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/lockout"
+)
+
+// LoginThrottle counts failed sign-ins per account and per client IP. Each
+// attempt is counted as a failure before the password or code is checked, so
+// parallel guesses cannot all slip under the limits, and is taken back if it
+// succeeds. Store errors are logged and let the attempt through, so an outage
+// of the counter store does not lock everybody out.
+type LoginThrottle struct {
+	Account *lockout.Limiter
+	IP      *lockout.Limiter
+	Logger  *log.Logger
+}
+
+func NewLoginThrottle(account, ip *lockout.Limiter, logger *log.Logger) *LoginThrottle {
+	return &LoginThrottle{
+		Account: account,
+		IP:      ip,
+		Logger:  logger,
+	}
+}
+
+// accountKey normalises an email so case variations share one counter, and
+// so unknown accounts are throttled just like real ones.
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Attempt counts a sign-in to the account and returns how long the client
+// must wait before it may be made. A failed attempt needs nothing further.
+func (lt *LoginThrottle) Attempt(ctx context.Context, email, ip string) time.Duration {
+	accountWait, err := lt.Account.Attempt(ctx, accountKey(email))
+	if err != nil {
+		lt.Logger.Printf("Error in LoginThrottle: Counting account attempt - %v", err)
+	}
+
+	ipWait, err := lt.IP.Attempt(ctx, ip)
+	if err != nil {
+		lt.Logger.Printf("Error in LoginThrottle: Counting ip attempt - %v", err)
+	}
+
+	return max(accountWait, ipWait)
+}
+
+// Release takes back an attempt whose password was right but which did not
+// sign in yet, e.g. because a second factor is still needed.
+func (lt *LoginThrottle) Release(ctx context.Context, email, ip string) {
+	err := lt.Account.Release(ctx, accountKey(email))
+	if err != nil {
+		lt.Logger.Printf("Error in LoginThrottle: Releasing account attempt - %v", err)
+	}
+
+	lt.releaseIP(ctx, ip)
+}
+
+// Succeed clears the account's failures. Only the IP's own attempt is taken
+// back, so an attacker cannot reset its counter by signing in to an account
+// of their own.
+func (lt *LoginThrottle) Succeed(ctx context.Context, email, ip string) {
+	err := lt.Account.Reset(ctx, accountKey(email))
+	if err != nil {
+		lt.Logger.Printf("Error in LoginThrottle: Resetting account failures - %v", err)
+	}
+
+	lt.releaseIP(ctx, ip)
+}
+
+func (lt *LoginThrottle) releaseIP(ctx context.Context, ip string) {
+	err := lt.IP.Release(ctx, ip)
+	if err != nil {
+		lt.Logger.Printf("Error in LoginThrottle: Releasing ip attempt - %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/auth"
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/lockout"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/oidc"
+	"github.com/trevortippery/moving-checklist/password"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+// oidcLoginTTL is how long a user has to finish signing in at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+type OIDCHandler struct {
+	client         *oidc.Client
+	identityStore  db.IdentityStore
+	userStore      db.UserStore
+	tokenStore     db.TokenStore
+	twoFactorStore db.TwoFactorStore
+	authenticator  auth.Authenticator
+	hasher         password.Hasher
+	// startLimiter counts sign-ins started per client IP, each of which
+	// stores a login state until it expires.
+	startLimiter *lockout.Limiter
+	cookies      middleware.SessionCookies
+	securityLog  *SecurityLog
+	logger       *log.Logger
+}
+
+func NewOIDCHandler(client *oidc.Client, identityStore db.IdentityStore, userStore db.UserStore, tokenStore db.TokenStore, twoFactorStore db.TwoFactorStore, authenticator auth.Authenticator, hasher password.Hasher, startLimiter *lockout.Limiter, cookies middleware.SessionCookies, securityLog *SecurityLog, logger *log.Logger) *OIDCHandler {
+	return &OIDCHandler{
+		client:         client,
+		identityStore:  identityStore,
+		userStore:      userStore,
+		tokenStore:     tokenStore,
+		twoFactorStore: twoFactorStore,
+		authenticator:  authenticator,
+		hasher:         hasher,
+		startLimiter:   startLimiter,
+		cookies:        cookies,
+		securityLog:    securityLog,
+		logger:         logger,
+	}
+}
+
+// HandleLogin starts a sign-in by redirecting to the provider.
+func (oh *OIDCHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleLogin"
+
+	if oh.client == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "single sign-on is not enabled"})
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	retryAfter, err := oh.startLimiter.RetryAfter(r.Context(), ip)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Checking sign-in limit - %v", funcName, err)
+	}
+
+	if retryAfter > 0 {
+		utils.WriteTooManyRequests(w, retryAfter)
+		return
+	}
+
+	_, err = oh.startLimiter.Fail(r.Context(), ip)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Counting sign-in - %v", funcName, err)
+	}
+
+	deviceName := r.URL.Query().Get("device_name")
+	cookie := r.URL.Query().Get("cookie") == "true"
+	if msg := validateDeviceName(deviceName); msg != "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"device_name": msg,
+		}})
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			oh.logger.Printf("Error in %s: Generating state - %v", funcName, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	expiry := time.Now().Add(oidcLoginTTL)
+
+	err = oh.identityStore.CreateLoginState(r.Context(), state, &db.LoginState{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		DeviceName:   deviceName,
+		Cookie:       cookie,
+		Expiry:       expiry,
+	})
+	if err != nil {
+		oh.logger.Printf("Error in %s: Storing login state - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	authURL, err := oh.client.AuthCodeURL(r.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		oh.logger.Printf("Error in %s: Building authorization URL - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadGateway, utils.Envelope{"error": "identity provider is unavailable"})
+		return
+	}
+
+	oh.cookies.SetOIDCState(w, state, expiry)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleCallback completes a sign-in. The first sign-in with an external
+// account creates a user for it; later ones sign in to that user.
+func (oh *OIDCHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleCallback"
+
+	if oh.client == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "single sign-on is not enabled"})
+		return
+	}
+
+	// The state cookie is only good for one attempt, whatever its outcome
+	validState := middleware.ValidOIDCState(r, r.URL.Query().Get("state"))
+	oh.cookies.ClearOIDCState(w)
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "sign-in was not completed: " + providerErr})
+		return
+	}
+
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "code and state are required"})
+		return
+	}
+
+	// A callback this browser did not start could sign it in to someone
+	// else's account
+	if !validState {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired sign-in, please start again"})
+		return
+	}
+
+	loginState, err := oh.identityStore.ConsumeLoginState(r.Context(), state)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Consuming login state - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	if loginState == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired sign-in, please start again"})
+		return
+	}
+
+	claims, err := oh.client.Exchange(r.Context(), code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Exchanging code - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "could not verify sign-in with the identity provider"})
+		return
+	}
+
+	user, err := oh.identityStore.GetUserByIdentity(r.Context(), oh.client.Issuer(), claims.Subject)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Getting user by identity - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	status := http.StatusOK
+	if user == nil {
+		user, status = oh.createUser(w, r, claims)
+		if user == nil {
+			return
+		}
+	} else {
+		if user.Locked() {
+			oh.securityLog.Record(r, user.ID, db.SecurityEventLoginFailed, map[string]any{"reason": "locked", "method": "oidc"})
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": errAccountLocked})
+			return
+		}
+
+		// Signing in through the provider does not skip the user's own second factor
+		pending, err := startTwoFactor(r, oh.tokenStore, oh.twoFactorStore, user, loginState.DeviceName)
+		if err != nil {
+			oh.logger.Printf("Error in %s: Starting two-factor sign-in - %v", funcName, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+			return
+		}
+
+		// A browser session is asked for again with the code
+		if pending != nil {
+			writeTwoFactorRequired(w, pending)
+			return
+		}
+	}
+
+	authToken, refreshToken, err := issueSessionTokens(r, oh.tokenStore, oh.authenticator, user, "", loginState.DeviceName)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Generating tokens - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to generate token"})
+		return
+	}
+
+	oh.securityLog.Record(r, user.ID, db.SecurityEventLoginSucceeded, map[string]any{
+		"method":      "oidc",
+		"device_name": loginState.DeviceName,
+	})
+
+	writeSessionTokens(w, status, utils.Envelope{"user": user}, oh.cookies, loginState.Cookie, authToken, refreshToken, oh.logger, funcName)
+}
+
+// createUser registers a user for a first-time external sign-in. It writes the
+// error response itself and returns nil if the user cannot be created.
+func (oh *OIDCHandler) createUser(w http.ResponseWriter, r *http.Request, claims *oidc.Claims) (*db.User, int) {
+	const funcName = "HandleCallback"
+
+	if claims.Email == "" || !emailRegex.MatchString(claims.Email) {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "the identity provider did not share a valid email address"})
+		return nil, 0
+	}
+
+	// Linking to an existing password account by email alone would let anyone
+	// who controls that address at the provider take the account over
+	exists, err := oh.userStore.CheckEmailExists(r.Context(), claims.Email)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Checking email - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return nil, 0
+	}
+
+	if exists {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "an account with this email already exists, sign in with your password instead"})
+		return nil, 0
+	}
+
+	username, err := oh.availableUsername(r, claims)
+	if err != nil {
+		oh.logger.Printf("Error in %s: Choosing username - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return nil, 0
+	}
+
+	// External accounts sign in through the provider; the random password is
+	// never shown and can only be replaced through a password reset
+	randomPassword, err := oidc.RandomString()
+	if err != nil {
+		oh.logger.Printf("Error in %s: Generating password - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return nil, 0
+	}
+
+	hashedPassword, err := oh.hasher.Hash([]byte(randomPassword))
+	if err != nil {
+		oh.logger.Printf("Error in %s: Hashing password - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return nil, 0
+	}
+
+	user := &db.User{
+		Username:     username,
+		Email:        claims.Email,
+		PasswordHash: hashedPassword,
+		Activated:    claims.EmailVerified,
+	}
+
+	err = oh.identityStore.CreateUserWithIdentity(r.Context(), user, &db.Identity{
+		Issuer:  oh.client.Issuer(),
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		oh.logger.Printf("Error in %s: Creating user - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create user"})
+		return nil, 0
+	}
+
+	oh.securityLog.Record(r, user.ID, db.SecurityEventRegistered, map[string]any{"method": "oidc", "issuer": oh.client.Issuer()})
+
+	return user, http.StatusCreated
+}
+
+// availableUsername derives a username from the provider's profile, adding a
+// numeric suffix when the preferred one is taken.
+func (oh *OIDCHandler) availableUsername(r *http.Request, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameDisallowed.ReplaceAllString(base, "")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for range 5 {
+		exists, err := oh.userStore.CheckUsernameExists(r.Context(), candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+
+		suffix, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%06d", base, suffix.Int64())
+	}
+
+	return "", fmt.Errorf("no free username for %q", base)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/utils"
+	"golang.org/x/text/language"
+)
+
+// maxReminderLeadMinutes is how far ahead of a due date a reminder can be set
+// for, 30 days.
+const maxReminderLeadMinutes = 30 * 24 * 60
+
+var (
+	dateFormats = []string{db.DateFormatISO, db.DateFormatDMY, db.DateFormatMDY, db.DateFormatDot}
+	weekStarts  = []string{db.WeekStartMonday, db.WeekStartSunday, db.WeekStartSaturday}
+)
+
+type PreferencesHandler struct {
+	preferencesStore db.PreferencesStore
+	logger           *log.Logger
+}
+
+type updatePreferencesRequest struct {
+	TimeZone            *string `json:"time_zone"`
+	Locale              *string `json:"locale"`
+	DateFormat          *string `json:"date_format"`
+	WeekStart           *string `json:"week_start"`
+	ReminderLeadMinutes *int    `json:"reminder_lead_minutes"`
+}
+
+func NewPreferencesHandler(preferencesStore db.PreferencesStore, logger *log.Logger) *PreferencesHandler {
+	return &PreferencesHandler{
+		preferencesStore: preferencesStore,
+		logger:           logger,
+	}
+}
+
+func (ph *PreferencesHandler) HandleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleGetPreferences"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	prefs, err := ph.preferencesStore.GetPreferences(r.Context(), user.ID)
+	if err != nil {
+		ph.logger.Printf("Error in %s: Getting preferences - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve preferences"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"preferences": prefs})
+}
+
+// HandleUpdatePreferences changes any of the preferences given and leaves the
+// rest as they are.
+func (ph *PreferencesHandler) HandleUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleUpdatePreferences"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	var input updatePreferencesRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		ph.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	prefs, err := ph.preferencesStore.GetPreferences(r.Context(), user.ID)
+	if err != nil {
+		ph.logger.Printf("Error in %s: Getting preferences - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not update preferences"})
+		return
+	}
+
+	validationErrors := applyPreferences(prefs, input)
+	if len(validationErrors) > 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": validationErrors})
+		return
+	}
+
+	err = ph.preferencesStore.UpdatePreferences(r.Context(), user.ID, prefs)
+	if err != nil {
+		ph.logger.Printf("Error in %s: Updating preferences - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not update preferences"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"preferences": prefs})
+}
+
+// applyPreferences copies the fields set in input onto prefs, returning an
+// error for each one that is invalid. Locales are stored in their canonical
+// form, so "en_gb" becomes "en-GB".
+func applyPreferences(prefs *db.Preferences, input updatePreferencesRequest) map[string]string {
+	validationErrors := make(map[string]string)
+
+	if input.TimeZone != nil {
+		// LoadLocation also accepts "Local" and "", neither of which means the
+		// same on every server
+		_, err := time.LoadLocation(*input.TimeZone)
+		if err != nil || *input.TimeZone == "" || *input.TimeZone == "Local" || len(*input.TimeZone) > 64 {
+			validationErrors["time_zone"] = "time_zone must be an IANA time zone such as America/Denver"
+		} else {
+			prefs.TimeZone = *input.TimeZone
+		}
+	}
+
+	if input.Locale != nil {
+		tag, err := language.Parse(*input.Locale)
+		if err != nil || len(tag.String()) > 35 {
+			validationErrors["locale"] = "locale must be a language tag such as en-US"
+		} else {
+			prefs.Locale = tag.String()
+		}
+	}
+
+	if input.DateFormat != nil {
+		if !slices.Contains(dateFormats, *input.DateFormat) {
+			validationErrors["date_format"] = "date_format must be one of YYYY-MM-DD, DD/MM/YYYY, MM/DD/YYYY or DD.MM.YYYY"
+		} else {
+			prefs.DateFormat = *input.DateFormat
+		}
+	}
+
+	if input.WeekStart != nil {
+		if !slices.Contains(weekStarts, *input.WeekStart) {
+			validationErrors["week_start"] = "week_start must be monday, sunday or saturday"
+		} else {
+			prefs.WeekStart = *input.WeekStart
+		}
+	}
+
+	if input.ReminderLeadMinutes != nil {
+		if *input.ReminderLeadMinutes < 0 || *input.ReminderLeadMinutes > maxReminderLeadMinutes {
+			validationErrors["reminder_lead_minutes"] = "reminder_lead_minutes must be between 0 and 43200 (30 days)"
+		} else {
+			prefs.ReminderLeadMinutes = *input.ReminderLeadMinutes
+		}
+	}
+
+	return validationErrors
+}
+
+// userLocation returns the time zone the user has chosen, which decides what
+// day it is for them.
+func userLocation(ctx context.Context, store db.PreferencesStore, userID int) (*time.Location, error) {
+	prefs, err := store.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return prefs.Location(), nil
+}
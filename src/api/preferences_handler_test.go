@@ -0,0 +1,74 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevortippery/moving-checklist/db"
+)
+
+func TestApplyPreferences(t *testing.T) {
+	ptr := func(s string) *string { return &s }
+	minutes := func(n int) *int { return &n }
+
+	tests := []struct {
+		name    string
+		input   updatePreferencesRequest
+		want    func(prefs *db.Preferences)
+		invalid string
+	}{
+		{
+			name:  "IANA time zone",
+			input: updatePreferencesRequest{TimeZone: ptr("America/Denver")},
+			want:  func(prefs *db.Preferences) { prefs.TimeZone = "America/Denver" },
+		},
+		{name: "Empty time zone", input: updatePreferencesRequest{TimeZone: ptr("")}, invalid: "time_zone"},
+		{name: "Local time zone", input: updatePreferencesRequest{TimeZone: ptr("Local")}, invalid: "time_zone"},
+		{name: "Unknown time zone", input: updatePreferencesRequest{TimeZone: ptr("Mars/Olympus_Mons")}, invalid: "time_zone"},
+		{
+			name:  "Locale is canonicalised",
+			input: updatePreferencesRequest{Locale: ptr("en_gb")},
+			want:  func(prefs *db.Preferences) { prefs.Locale = "en-GB" },
+		},
+		{
+			name:  "Locale with script",
+			input: updatePreferencesRequest{Locale: ptr("ZH-hant-tw")},
+			want:  func(prefs *db.Preferences) { prefs.Locale = "zh-Hant-TW" },
+		},
+		{name: "Invalid locale", input: updatePreferencesRequest{Locale: ptr("not a locale")}, invalid: "locale"},
+		{
+			name:  "Date format",
+			input: updatePreferencesRequest{DateFormat: ptr(db.DateFormatDMY)},
+			want:  func(prefs *db.Preferences) { prefs.DateFormat = db.DateFormatDMY },
+		},
+		{name: "Unknown date format", input: updatePreferencesRequest{DateFormat: ptr("YY/MM/DD")}, invalid: "date_format"},
+		{name: "Unknown week start", input: updatePreferencesRequest{WeekStart: ptr("friday")}, invalid: "week_start"},
+		{
+			name:  "No reminder lead",
+			input: updatePreferencesRequest{ReminderLeadMinutes: minutes(0)},
+			want:  func(prefs *db.Preferences) { prefs.ReminderLeadMinutes = 0 },
+		},
+		{name: "Negative reminder lead", input: updatePreferencesRequest{ReminderLeadMinutes: minutes(-1)}, invalid: "reminder_lead_minutes"},
+		{name: "Reminder lead too long", input: updatePreferencesRequest{ReminderLeadMinutes: minutes(maxReminderLeadMinutes + 1)}, invalid: "reminder_lead_minutes"},
+		{name: "Nothing set", input: updatePreferencesRequest{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := db.DefaultPreferences()
+			want := db.DefaultPreferences()
+			if tt.want != nil {
+				tt.want(want)
+			}
+
+			validationErrors := applyPreferences(prefs, tt.input)
+			if tt.invalid != "" {
+				assert.Contains(t, validationErrors, tt.invalid)
+				assert.Len(t, validationErrors, 1)
+			} else {
+				assert.Empty(t, validationErrors)
+			}
+			assert.Equal(t, want, prefs, "invalid fields leave the preference unchanged")
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+// SecurityLog records account and authentication events. A store error is
+// logged but never fails the request the event describes.
+type SecurityLog struct {
+	Store  db.SecurityEventStore
+	Logger *log.Logger
+}
+
+func NewSecurityLog(store db.SecurityEventStore, logger *log.Logger) *SecurityLog {
+	return &SecurityLog{
+		Store:  store,
+		Logger: logger,
+	}
+}
+
+// Record logs event for the account with userID, or for no known account if
+// userID is 0. The actor is the signed-in user; on public routes it is the
+// account itself, having proved who it is with a password or token, except
+// for failed logins, which nobody is known to have made.
+func (sl *SecurityLog) Record(r *http.Request, userID int, event string, details map[string]any) {
+	entry := &db.SecurityEvent{
+		Event:     event,
+		Details:   details,
+		IP:        utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	if userID != 0 {
+		entry.UserID = &userID
+	}
+
+	if user := middleware.GetUser(r); user != nil {
+		entry.ActorID = &user.ID
+	} else if event != db.SecurityEventLoginFailed {
+		entry.ActorID = entry.UserID
+	}
+
+	err := sl.Store.RecordEvent(r.Context(), entry)
+	if err != nil {
+		sl.Logger.Printf("Error in SecurityLog: Recording %s - %v", event, err)
+	}
+}
+
+// HandleListSecurityEvents lists the signed-in user's security events, newest
+// first.
+func (uh *UserHandler) HandleListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleListSecurityEvents"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	limit, offset, ok := readPage(w, r)
+	if !ok {
+		return
+	}
+
+	events, err := uh.securityLog.Store.ListEvents(r.Context(), int64(user.ID), "", limit, offset)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Listing events - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve security events"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"events": events})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/tokens"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+// maxDeviceNameLength is the longest device name, in bytes, a session can be
+// given.
+const maxDeviceNameLength = 100
+
+type SessionHandler struct {
+	tokenStore  db.TokenStore
+	securityLog *SecurityLog
+	logger      *log.Logger
+}
+
+type sessionResponse struct {
+	ID         int64      `json:"id"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
+}
+
+func NewSessionHandler(tokenStore db.TokenStore, securityLog *SecurityLog, logger *log.Logger) *SessionHandler {
+	return &SessionHandler{
+		tokenStore:  tokenStore,
+		securityLog: securityLog,
+		logger:      logger,
+	}
+}
+
+func (sh *SessionHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleListSessions"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	current, err := sh.currentSession(r)
+	if err != nil {
+		sh.logger.Printf("Error in %s: Getting current session - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve sessions"})
+		return
+	}
+
+	sessions, err := sh.tokenStore.ListSessionsForUser(r.Context(), int64(user.ID))
+	if err != nil {
+		sh.logger.Printf("Error in %s: Listing sessions - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve sessions"})
+		return
+	}
+
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionResponse{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Expiry:     session.Expiry,
+			Current:    sameSession(current, session),
+		})
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"sessions": response})
+}
+
+func (sh *SessionHandler) HandleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleDeleteSession"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	sessionID, err := utils.ReadIDParam(r)
+	if err != nil {
+		sh.logger.Printf("Error in %s: Reading session ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid session ID"})
+		return
+	}
+
+	current, err := sh.currentSession(r)
+	if err != nil {
+		sh.logger.Printf("Error in %s: Getting current session - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke session"})
+		return
+	}
+
+	session, err := sh.tokenStore.GetSessionByID(r.Context(), int64(user.ID), sessionID)
+	if errors.Is(err, db.ErrSessionNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "session not found"})
+		return
+	}
+
+	if err != nil {
+		sh.logger.Printf("Error in %s: Getting session %d - %v", funcName, sessionID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke session"})
+		return
+	}
+
+	if sameSession(current, session) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "use DELETE /tokens/current to sign out of the current session"})
+		return
+	}
+
+	err = sh.tokenStore.DeleteSession(r.Context(), int64(user.ID), sessionID)
+	if errors.Is(err, db.ErrSessionNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "session not found"})
+		return
+	}
+
+	if err != nil {
+		sh.logger.Printf("Error in %s: Deleting session %d - %v", funcName, sessionID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke session"})
+		return
+	}
+
+	sh.securityLog.Record(r, user.ID, db.SecurityEventTokenRevoked, map[string]any{
+		"kind":        "session",
+		"session_id":  sessionID,
+		"device_name": session.DeviceName,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sh *SessionHandler) currentSession(r *http.Request) (*tokens.Token, error) {
+	return sh.tokenStore.GetToken(r.Context(), middleware.GetToken(r), tokens.ScopeAuth)
+}
+
+func sameSession(current, session *tokens.Token) bool {
+	if current == nil {
+		return false
+	}
+	if current.Family != "" {
+		return current.Family == session.Family
+	}
+	return bytes.Equal(current.Hash, session.Hash)
+}
+
+// validateDeviceName checks the name a client gives the session it signs in
+// with, returning an error message or "".
+func validateDeviceName(name string) string {
+	if len(name) > maxDeviceNameLength {
+		return "device_name must be at most 100 characters"
+	}
+	return ""
+}
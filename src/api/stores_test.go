@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/mailer"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+// The stores below keep just enough in memory for handler tests. Methods a
+// test does not need are left to the embedded interface and panic if called.
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+type memTokenStore struct {
+	db.TokenStore
+	mu     sync.Mutex
+	tokens []*tokens.Token
+	used   map[*tokens.Token]bool
+}
+
+func (ms *memTokenStore) Insert(ctx context.Context, token *tokens.Token) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.tokens = append(ms.tokens, token)
+	return nil
+}
+
+func (ms *memTokenStore) GenerateToken(ctx context.Context, userID int64, ttl time.Duration, scope string) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(int(userID), ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	return token, ms.Insert(ctx, token)
+}
+
+func (ms *memTokenStore) find(plaintext, scope string) *tokens.Token {
+	for _, token := range ms.tokens {
+		if bytes.Equal(token.Hash, tokens.HashToken(plaintext)) && token.Scope == scope && time.Now().Before(token.Expiry) {
+			return token
+		}
+	}
+	return nil
+}
+
+func (ms *memTokenStore) ConsumeRefreshToken(ctx context.Context, plaintext string) (*tokens.Token, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	token := ms.find(plaintext, tokens.ScopeRefresh)
+	if token == nil {
+		return nil, nil
+	}
+	if ms.used == nil {
+		ms.used = map[*tokens.Token]bool{}
+	}
+	if ms.used[token] {
+		return token, db.ErrTokenReused
+	}
+	ms.used[token] = true
+	return token, nil
+}
+
+func (ms *memTokenStore) DeleteAllTokensForUser(ctx context.Context, userID int64, scopes ...string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var kept []*tokens.Token
+	for _, token := range ms.tokens {
+		if int64(token.UserID) != userID || !slices.Contains(scopes, token.Scope) {
+			kept = append(kept, token)
+		}
+	}
+	revoked := int64(len(ms.tokens) - len(kept))
+	ms.tokens = kept
+	return revoked, nil
+}
+
+func (ms *memTokenStore) count(userID int, scope string) int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	n := 0
+	for _, token := range ms.tokens {
+		if token.UserID == userID && token.Scope == scope {
+			n++
+		}
+	}
+	return n
+}
+
+type memUserStore struct {
+	db.UserStore
+	mu     sync.Mutex
+	users  map[int]*db.User
+	tokens *memTokenStore
+}
+
+func (ms *memUserStore) GetUserByID(ctx context.Context, id int64) (*db.User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, ok := ms.users[int(id)]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *user
+	return &copied, nil
+}
+
+type memSecurityEventStore struct {
+	db.SecurityEventStore
+	mu     sync.Mutex
+	events []*db.SecurityEvent
+}
+
+func (ms *memSecurityEventStore) RecordEvent(ctx context.Context, event *db.SecurityEvent) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.events = append(ms.events, event)
+	return nil
+}
+
+// plainHasher stands in for argon2id, which is too slow to run per test.
+type plainHasher struct{}
+
+func (plainHasher) Hash(password []byte) (string, error) {
+	return "plain:" + string(password), nil
+}
+
+func (plainHasher) Verify(hash string, password []byte) (bool, bool, error) {
+	return hash == "plain:"+string(password), false, nil
+}
+
+func (ms *memUserStore) CheckUsernameExists(ctx context.Context, username string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, user := range ms.users {
+		if strings.EqualFold(user.Username, username) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ms *memUserStore) CheckEmailExists(ctx context.Context, email string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, user := range ms.users {
+		if strings.EqualFold(user.Email, email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// UpdateUser records activation in tokens, when set, the way the database
+// store does in the same transaction.
+func (ms *memUserStore) UpdateUser(ctx context.Context, user *db.User, activation *tokens.Token) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[user.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	copied := *user
+	ms.users[user.ID] = &copied
+
+	if activation != nil && ms.tokens != nil {
+		_, err := ms.tokens.DeleteAllTokensForUser(ctx, int64(user.ID), tokens.ScopeActivation)
+		if err != nil {
+			return err
+		}
+		return ms.tokens.Insert(ctx, activation)
+	}
+	return nil
+}
+
+type memMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (mm *memMailer) Send(ctx context.Context, msg mailer.Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.messages = append(mm.messages, msg)
+	return nil
+}
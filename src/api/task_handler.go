@@ -17,10 +17,27 @@ import (
 )
 
 type TaskHandler struct {
-	task   db.TaskStore
-	logger *log.Logger
+	task        db.TaskStore
+	preferences db.PreferencesStore
+	logger      *log.Logger
 }
 
+// taskResponse is a task as shown to its owner, with whether it is overdue or
+// due today in their time zone.
+type taskResponse struct {
+	*db.Task
+	Overdue  bool `json:"overdue"`
+	DueToday bool `json:"due_today"`
+}
+
+func newTaskResponse(task *db.Task, now time.Time) taskResponse {
+	overdue, dueToday := task.DueStatus(now)
+	return taskResponse{Task: task, Overdue: overdue, DueToday: dueToday}
+}
+
+// TaskRequest is the body of a new task. DueDate is an RFC 3339 time, or a
+// date such as 2025-06-01 for a task due at any time that day in the user's
+// time zone, whichever it is when they ask.
 type TaskRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -36,10 +53,11 @@ const (
 	ValidateUpdate ValidationMode = "update"
 )
 
-func NewTaskHandler(taskStore db.TaskStore, logger *log.Logger) *TaskHandler {
+func NewTaskHandler(taskStore db.TaskStore, preferencesStore db.PreferencesStore, logger *log.Logger) *TaskHandler {
 	return &TaskHandler{
-		task:   taskStore,
-		logger: logger,
+		task:        taskStore,
+		preferences: preferencesStore,
+		logger:      logger,
 	}
 }
 
@@ -67,16 +85,15 @@ func (th *TaskHandler) HandleCreateTask(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var dueDate sql.NullTime
-	if input.DueDate != "" {
-		parsed, parseErr := time.Parse(time.RFC3339, input.DueDate)
-		if parseErr != nil {
-			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid due_date format, must be RFC3339"})
-			return
-		}
-		dueDate = sql.NullTime{Time: parsed, Valid: true}
+	loc, err := userLocation(r.Context(), th.preferences, user.ID)
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting time zone - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create task"})
+		return
 	}
 
+	dueDate, dueOn := parseDueDate(input.DueDate)
+
 	task := db.Task{
 		UserID:      user.ID,
 		Name:        input.Name,
@@ -84,6 +101,7 @@ func (th *TaskHandler) HandleCreateTask(w http.ResponseWriter, r *http.Request)
 		Category:    input.Category,
 		IsComplete:  input.IsComplete,
 		DueDate:     dueDate,
+		DueOn:       dueOn,
 	}
 
 	createdTask, err := th.task.CreateTask(r.Context(), &task)
@@ -93,7 +111,7 @@ func (th *TaskHandler) HandleCreateTask(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"task": createdTask})
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"task": newTaskResponse(createdTask, time.Now().In(loc))})
 }
 
 func (th *TaskHandler) HandleDeleteTask(w http.ResponseWriter, r *http.Request) {
@@ -164,12 +182,13 @@ func (th *TaskHandler) HandleUpdateTask(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// An empty due_date clears it
 	var updateTaskRequest struct {
-		Name        *string       `json:"name"`
-		Description *string       `json:"description"`
-		Category    *string       `json:"category"`
-		IsComplete  *bool         `json:"is_complete"`
-		DueDate     *sql.NullTime `json:"due_date"`
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Category    *string `json:"category"`
+		IsComplete  *bool   `json:"is_complete"`
+		DueDate     *string `json:"due_date"`
 	}
 
 	defer r.Body.Close()
@@ -184,7 +203,7 @@ func (th *TaskHandler) HandleUpdateTask(w http.ResponseWriter, r *http.Request)
 	taskReq := TaskRequest{
 		Name:     derefString(updateTaskRequest.Name),
 		Category: derefString(updateTaskRequest.Category),
-		DueDate:  derefNullTime(updateTaskRequest.DueDate),
+		DueDate:  derefString(updateTaskRequest.DueDate),
 	}
 
 	validationErrors := validateTaskInput(taskReq, ValidateUpdate)
@@ -210,7 +229,14 @@ func (th *TaskHandler) HandleUpdateTask(w http.ResponseWriter, r *http.Request)
 	}
 
 	if updateTaskRequest.DueDate != nil {
-		existingTask.DueDate = *updateTaskRequest.DueDate
+		existingTask.DueDate, existingTask.DueOn = parseDueDate(*updateTaskRequest.DueDate)
+	}
+
+	loc, err := userLocation(r.Context(), th.preferences, user.ID)
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting time zone - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not update task"})
+		return
 	}
 
 	now := time.Now().UTC()
@@ -230,7 +256,7 @@ func (th *TaskHandler) HandleUpdateTask(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"task": existingTask})
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"task": newTaskResponse(existingTask, time.Now().In(loc))})
 }
 
 func (th *TaskHandler) HandleGetTaskByID(w http.ResponseWriter, r *http.Request) {
@@ -262,7 +288,14 @@ func (th *TaskHandler) HandleGetTaskByID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"task": requestedTask})
+	loc, err := userLocation(r.Context(), th.preferences, user.ID)
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting time zone - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve task"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"task": newTaskResponse(requestedTask, time.Now().In(loc))})
 }
 
 func validateTaskInput(input TaskRequest, mode ValidationMode) map[string]string {
@@ -281,8 +314,12 @@ func validateTaskInput(input TaskRequest, mode ValidationMode) map[string]string
 	}
 
 	if input.DueDate != "" {
-		if _, err := time.Parse(time.RFC3339, input.DueDate); err != nil {
-			errors["due_date"] = "due_date must be in RFC3339 format (e.g., 2025-05-17T15:04:05Z)"
+		_, err := time.Parse(time.RFC3339, input.DueDate)
+		if err != nil {
+			_, err = time.Parse(time.DateOnly, input.DueDate)
+		}
+		if err != nil {
+			errors["due_date"] = "due_date must be in RFC3339 format (e.g., 2025-05-17T15:04:05Z) or a date (e.g., 2025-05-17)"
 		}
 	}
 
@@ -296,9 +333,20 @@ func derefString(s *string) string {
 	return *s
 }
 
-func derefNullTime(nt *sql.NullTime) string {
-	if nt == nil || !nt.Valid {
-		return ""
+// parseDueDate splits a due date that has passed validateTaskInput into the
+// time the task is due at or the date it is due on. A date is kept as it is,
+// so "2025-06-01" stays June 1 wherever the user lives and when they move. An
+// empty value clears both.
+func parseDueDate(value string) (sql.NullTime, *string) {
+	due, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return sql.NullTime{Time: due, Valid: true}, nil
 	}
-	return nt.Time.Format(time.RFC3339)
+
+	_, err = time.Parse(time.DateOnly, value)
+	if err == nil {
+		return sql.NullTime{}, &value
+	}
+
+	return sql.NullTime{}, nil
 }
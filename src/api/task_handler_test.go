@@ -0,0 +1,57 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTaskDueDate(t *testing.T) {
+	tests := []struct {
+		dueDate string
+		valid   bool
+	}{
+		{"", true},
+		{"2025-06-01T15:04:05Z", true},
+		{"2025-06-01T15:04:05-06:00", true},
+		{"2025-06-01", true},
+		{"2025-6-1", false},
+		{"2025-06-31", false},
+		{"2025-06-01T15:04:05", false},
+		{"06/01/2025", false},
+		{"tomorrow", false},
+	}
+
+	for _, tt := range tests {
+		validationErrors := validateTaskInput(TaskRequest{Name: "Book movers", DueDate: tt.dueDate}, ValidateCreate)
+		if tt.valid {
+			assert.Empty(t, validationErrors, tt.dueDate)
+		} else {
+			assert.Contains(t, validationErrors, "due_date", tt.dueDate)
+		}
+	}
+}
+
+func TestParseDueDate(t *testing.T) {
+	t.Run("RFC 3339 is due at a time", func(t *testing.T) {
+		dueDate, dueOn := parseDueDate("2025-06-01T15:04:05-06:00")
+		assert.True(t, dueDate.Valid)
+		assert.True(t, dueDate.Time.Equal(time.Date(2025, 6, 1, 21, 4, 5, 0, time.UTC)))
+		assert.Nil(t, dueOn)
+	})
+
+	t.Run("Date only is due on the date", func(t *testing.T) {
+		dueDate, dueOn := parseDueDate("2025-06-01")
+		assert.False(t, dueDate.Valid)
+		if assert.NotNil(t, dueOn) {
+			assert.Equal(t, "2025-06-01", *dueOn)
+		}
+	})
+
+	t.Run("Empty clears both", func(t *testing.T) {
+		dueDate, dueOn := parseDueDate("")
+		assert.False(t, dueDate.Valid)
+		assert.Nil(t, dueOn)
+	})
+}
@@ -0,0 +1,619 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/trevortippery/moving-checklist/auth"
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/mailer"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/password"
+	"github.com/trevortippery/moving-checklist/tokens"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+type TokenHandler struct {
+	tokenStore     db.TokenStore
+	userStore      db.UserStore
+	twoFactorStore db.TwoFactorStore
+	authenticator  auth.Authenticator
+	throttle       *LoginThrottle
+	securityLog    *SecurityLog
+	mailer         mailer.Mailer
+	cookies        middleware.SessionCookies
+	hasher         password.Hasher
+	// dummyHash is compared against when no user matches the login identifier so
+	// that unknown accounts take as long to reject as wrong passwords.
+	dummyHash string
+	logger    *log.Logger
+}
+
+type createTokenRequest struct {
+	// Identifier is the account's username or email. Email is still accepted
+	// in its place from clients written before usernames could sign in.
+	Identifier string `json:"identifier"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	DeviceName string `json:"device_name"`
+	// Cookie asks for a browser session in cookies instead of tokens in the
+	// response body.
+	Cookie bool `json:"cookie"`
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type twoFactorTokenRequest struct {
+	TwoFactorToken string `json:"two_factor_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+	Cookie         bool   `json:"cookie"`
+}
+
+type passwordResetTokenRequest struct {
+	Email string `json:"email"`
+}
+
+func NewTokenHandler(tokenStore db.TokenStore, userStore db.UserStore, twoFactorStore db.TwoFactorStore, authenticator auth.Authenticator, throttle *LoginThrottle, mailer mailer.Mailer, hasher password.Hasher, cookies middleware.SessionCookies, securityLog *SecurityLog, logger *log.Logger) *TokenHandler {
+	dummyHash, _ := hasher.Hash([]byte("moving-checklist-dummy-password"))
+
+	return &TokenHandler{
+		tokenStore:     tokenStore,
+		userStore:      userStore,
+		twoFactorStore: twoFactorStore,
+		authenticator:  authenticator,
+		throttle:       throttle,
+		mailer:         mailer,
+		hasher:         hasher,
+		dummyHash:      dummyHash,
+		cookies:        cookies,
+		securityLog:    securityLog,
+		logger:         logger,
+	}
+}
+
+func (th *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleCreateToken"
+
+	var input createTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		th.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	identifier := strings.TrimSpace(input.Identifier)
+	if identifier == "" {
+		identifier = strings.TrimSpace(input.Email)
+	}
+
+	if identifier == "" || input.Password == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"credentials": "identifier (username or email) and password are required",
+		}})
+		return
+	}
+
+	if msg := validateDeviceName(input.DeviceName); msg != "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"device_name": msg,
+		}})
+		return
+	}
+
+	user, err := th.userStore.GetUserByIdentifier(r.Context(), identifier)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		th.logger.Printf("Error in %s: Get user by identifier - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	// Failures count against the account's email whichever identifier was
+	// typed, so switching to the username does not start a fresh count
+	throttleKey := identifier
+	if user != nil {
+		throttleKey = user.Email
+	}
+
+	ip := utils.ClientIP(r)
+	if retryAfter := th.throttle.Attempt(r.Context(), throttleKey, ip); retryAfter > 0 {
+		utils.WriteTooManyRequests(w, retryAfter)
+		return
+	}
+
+	if user == nil {
+		th.hasher.Verify(th.dummyHash, []byte(input.Password))
+		th.securityLog.Record(r, 0, db.SecurityEventLoginFailed, map[string]any{
+			"reason":     "unknown_account",
+			"identifier": identifier,
+		})
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid credentials"})
+		return
+	}
+
+	match, rehash, err := th.hasher.Verify(user.PasswordHash, []byte(input.Password))
+	if err != nil {
+		th.logger.Printf("Error in %s: Checking password - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	if !match {
+		th.securityLog.Record(r, user.ID, db.SecurityEventLoginFailed, map[string]any{"reason": "wrong_password"})
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid credentials"})
+		return
+	}
+
+	if rehash {
+		th.rehashPassword(r, user, input.Password)
+	}
+
+	if user.Locked() {
+		th.throttle.Release(r.Context(), throttleKey, ip)
+		th.securityLog.Record(r, user.ID, db.SecurityEventLoginFailed, map[string]any{"reason": "locked"})
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": errAccountLocked})
+		return
+	}
+
+	pending, err := startTwoFactor(r, th.tokenStore, th.twoFactorStore, user, input.DeviceName)
+	if err != nil {
+		th.logger.Printf("Error in %s: Starting two-factor sign-in - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	// With two-factor authentication on, the account's failures are only
+	// cleared once the code is right as well
+	if pending != nil {
+		th.throttle.Release(r.Context(), throttleKey, ip)
+		writeTwoFactorRequired(w, pending)
+		return
+	}
+
+	th.throttle.Succeed(r.Context(), throttleKey, ip)
+
+	authToken, refreshToken, err := issueSessionTokens(r, th.tokenStore, th.authenticator, user, "", input.DeviceName)
+	if err != nil {
+		th.logger.Printf("Error in %s: Generating tokens - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to generate token"})
+		return
+	}
+
+	th.securityLog.Record(r, user.ID, db.SecurityEventLoginSucceeded, map[string]any{
+		"method":      "password",
+		"device_name": input.DeviceName,
+	})
+
+	th.writeSessionTokens(w, funcName, input.Cookie, authToken, refreshToken)
+}
+
+// rehashPassword replaces a hash made with bcrypt or outdated argon2id
+// parameters now that the plain password is at hand. Failing only costs the
+// upgrade, so the sign-in carries on either way.
+func (th *TokenHandler) rehashPassword(r *http.Request, user *db.User, plain string) {
+	const funcName = "rehashPassword"
+
+	hash, err := th.hasher.Hash([]byte(plain))
+	if err != nil {
+		th.logger.Printf("Error in %s: Hashing password - %v", funcName, err)
+		return
+	}
+
+	err = th.userStore.UpdatePasswordHash(r.Context(), user.ID, user.PasswordHash, hash)
+	if err != nil {
+		th.logger.Printf("Error in %s: Updating password hash - %v", funcName, err)
+		return
+	}
+
+	user.PasswordHash = hash
+}
+
+// HandleCreateTwoFactorToken finishes a two-factor sign-in by exchanging the
+// 2fa-pending token and a TOTP or recovery code for the usual tokens. A pending
+// token is good for one attempt; after a wrong code the user signs in again.
+func (th *TokenHandler) HandleCreateTwoFactorToken(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleCreateTwoFactorToken"
+
+	var input twoFactorTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		th.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if strings.TrimSpace(input.TwoFactorToken) == "" || (strings.TrimSpace(input.Code) == "" && strings.TrimSpace(input.RecoveryCode) == "") {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"code": "two_factor_token and either code or recovery_code are required",
+		}})
+		return
+	}
+
+	pending, err := th.tokenStore.GetToken(r.Context(), input.TwoFactorToken, tokens.ScopeTwoFactor)
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting pending token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	if pending == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired two-factor token, please sign in again"})
+		return
+	}
+
+	// Consuming the pending token first means concurrent attempts cannot both
+	// get a guess in
+	err = th.tokenStore.DeleteToken(r.Context(), input.TwoFactorToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired two-factor token, please sign in again"})
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("Error in %s: Deleting pending token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	user, err := th.userStore.GetUserByID(r.Context(), int64(pending.UserID))
+	if err != nil {
+		th.logger.Printf("Error in %s: Get user by ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	if retryAfter := th.throttle.Attempt(r.Context(), user.Email, ip); retryAfter > 0 {
+		utils.WriteTooManyRequests(w, retryAfter)
+		return
+	}
+
+	ok, err := verifySecondFactor(r, th.twoFactorStore, pending.UserID, strings.TrimSpace(input.Code), input.RecoveryCode)
+	if err != nil {
+		th.logger.Printf("Error in %s: Verifying code - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	if !ok {
+		th.securityLog.Record(r, user.ID, db.SecurityEventLoginFailed, map[string]any{"reason": "wrong_code"})
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid code, please sign in again"})
+		return
+	}
+
+	th.throttle.Succeed(r.Context(), user.Email, ip)
+
+	authToken, refreshToken, err := issueSessionTokens(r, th.tokenStore, th.authenticator, user, "", pending.DeviceName)
+	if err != nil {
+		th.logger.Printf("Error in %s: Generating tokens - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to generate token"})
+		return
+	}
+
+	th.securityLog.Record(r, user.ID, db.SecurityEventLoginSucceeded, map[string]any{
+		"method":      "two_factor",
+		"device_name": pending.DeviceName,
+	})
+
+	th.writeSessionTokens(w, funcName, input.Cookie, authToken, refreshToken)
+}
+
+func (th *TokenHandler) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleRefreshToken"
+
+	// Browser sessions may send an empty body and the refresh token cookie
+	var input refreshTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil && !errors.Is(err, io.EOF) {
+		th.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	fromCookie := false
+	if cookie, err := r.Cookie(middleware.RefreshCookie); err == nil && input.RefreshToken == "" {
+		if !middleware.ValidCSRF(r) {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "missing or invalid CSRF token"})
+			return
+		}
+		input.RefreshToken = cookie.Value
+		fromCookie = true
+	}
+
+	if strings.TrimSpace(input.RefreshToken) == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"refresh_token": "refresh_token is required",
+		}})
+		return
+	}
+
+	consumed, err := th.tokenStore.ConsumeRefreshToken(r.Context(), input.RefreshToken)
+	if errors.Is(err, db.ErrTokenReused) {
+		th.logger.Printf("Warning in %s: Refresh token reused, token family revoked", funcName)
+		th.securityLog.Record(r, consumed.UserID, db.SecurityEventTokenRevoked, map[string]any{
+			"kind":        "session",
+			"reason":      "reuse",
+			"device_name": consumed.DeviceName,
+		})
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("Error in %s: Consuming refresh token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	if consumed == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	user, err := th.userStore.GetUserByID(r.Context(), int64(consumed.UserID))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("Error in %s: Get user by ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
+	authToken, refreshToken, err := issueSessionTokens(r, th.tokenStore, th.authenticator, user, consumed.Family, consumed.DeviceName)
+	if err != nil {
+		th.logger.Printf("Error in %s: Generating tokens - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to generate token"})
+		return
+	}
+
+	th.securityLog.Record(r, user.ID, db.SecurityEventTokenIssued, map[string]any{
+		"kind":        "refresh",
+		"device_name": consumed.DeviceName,
+	})
+
+	th.writeSessionTokens(w, funcName, fromCookie, authToken, refreshToken)
+}
+
+// HandleCreatePasswordResetToken emails a password reset token to the account
+// with the given address. It responds 202 whether or not the account exists so
+// the endpoint cannot be used to discover registered emails.
+func (th *TokenHandler) HandleCreatePasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleCreatePasswordResetToken"
+
+	var input passwordResetTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		th.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if strings.TrimSpace(input.Email) == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"email": "email is required",
+		}})
+		return
+	}
+
+	accepted := utils.Envelope{"message": "if an account with that email exists, a password reset token has been sent"}
+
+	user, err := th.userStore.GetUserByEmail(r.Context(), input.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("Error in %s: Get user by email - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	// Only the most recently requested reset token is valid
+	_, err = th.tokenStore.DeleteAllTokensForUser(r.Context(), int64(user.ID), tokens.ScopePasswordReset)
+	if err != nil {
+		th.logger.Printf("Error in %s: Deleting old reset tokens - %v", funcName, err)
+	}
+
+	token, err := th.tokenStore.GenerateToken(r.Context(), int64(user.ID), tokens.PasswordResetTTL, tokens.ScopePasswordReset)
+	if err != nil {
+		th.logger.Printf("Error in %s: Generating reset token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	th.securityLog.Record(r, user.ID, db.SecurityEventTokenIssued, map[string]any{"kind": "password_reset"})
+
+	err = th.mailer.Send(r.Context(), mailer.PasswordResetMessage(user.Email, user.Username, token.Plaintext))
+	if err != nil {
+		th.logger.Printf("Error in %s: Sending reset email - %v", funcName, err)
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+func (th *TokenHandler) HandleDeleteCurrentToken(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleDeleteCurrentToken"
+
+	token := middleware.GetToken(r)
+	if token == "" {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	current, err := th.tokenStore.GetToken(r.Context(), token, tokens.ScopeAuth)
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke token"})
+		return
+	}
+
+	if current == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired token"})
+		return
+	}
+
+	// Logging out also revokes the refresh token issued alongside this token
+	if current.Family != "" {
+		_, err = th.tokenStore.DeleteTokenFamily(r.Context(), current.Family)
+	} else {
+		err = th.tokenStore.DeleteToken(r.Context(), token)
+	}
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		th.logger.Printf("Error in %s: Deleting token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke token"})
+		return
+	}
+
+	th.securityLog.Record(r, current.UserID, db.SecurityEventTokenRevoked, map[string]any{
+		"kind":   "session",
+		"reason": "logout",
+	})
+
+	if middleware.HasSessionCookie(r) {
+		th.cookies.Clear(w)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (th *TokenHandler) HandleDeleteAllTokens(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleDeleteAllTokens"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	if scope == "" {
+		scope = tokens.ScopeAuth
+	}
+
+	if !slices.Contains(tokens.Scopes, scope) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"scope": "scope must be one of " + strings.Join(tokens.Scopes, ", "),
+		}})
+		return
+	}
+
+	// Signing out ends whole sessions. Left behind, a refresh token would
+	// let a signed-out device straight back in
+	scopes := []string{scope}
+	if scope == tokens.ScopeAuth {
+		scopes = append(scopes, tokens.ScopeRefresh)
+	}
+
+	revoked, err := th.tokenStore.DeleteAllTokensForUser(r.Context(), int64(user.ID), scopes...)
+	if err != nil {
+		th.logger.Printf("Error in %s: Deleting tokens for user %d - %v", funcName, user.ID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke tokens"})
+		return
+	}
+
+	th.securityLog.Record(r, user.ID, db.SecurityEventTokenRevoked, map[string]any{
+		"kind":    scope,
+		"reason":  "logout_everywhere",
+		"revoked": revoked,
+	})
+
+	if scope == tokens.ScopeAuth && middleware.HasSessionCookie(r) {
+		th.cookies.Clear(w)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"revoked": revoked})
+}
+
+func (th *TokenHandler) writeSessionTokens(w http.ResponseWriter, funcName string, cookie bool, authToken, refreshToken *tokens.Token) {
+	writeSessionTokens(w, http.StatusCreated, utils.Envelope{}, th.cookies, cookie, authToken, refreshToken, th.logger, funcName)
+}
+
+// writeSessionTokens responds with status, body and a new pair of session
+// tokens, in the body for API clients or in cookies for browser sessions. A
+// browser only gets the CSRF token and when the auth token expires so it knows
+// when to refresh.
+func writeSessionTokens(w http.ResponseWriter, status int, body utils.Envelope, cookies middleware.SessionCookies, cookie bool, authToken, refreshToken *tokens.Token, logger *log.Logger, funcName string) {
+	if !cookie {
+		body["auth_token"] = authToken
+		body["refresh_token"] = refreshToken
+		utils.WriteJSON(w, status, body)
+		return
+	}
+
+	csrfToken, err := cookies.Set(w, authToken, refreshToken)
+	if err != nil {
+		logger.Printf("Error in %s: Setting session cookies - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to generate token"})
+		return
+	}
+
+	body["csrf_token"] = csrfToken
+	body["expiry"] = authToken.Expiry
+	utils.WriteJSON(w, status, body)
+}
+
+// writeTwoFactorRequired tells the client to finish signing in with a code,
+// using the pending token, on POST /tokens/2fa.
+func writeTwoFactorRequired(w http.ResponseWriter, pending *tokens.Token) {
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{
+		"two_factor_required": true,
+		"two_factor_token":    pending,
+	})
+}
+
+// issueSessionTokens mints a short-lived auth token and a single-use refresh
+// token for the user, tagged with the requesting client. An empty family starts
+// a new sign-in; passing an existing family continues it after a refresh token
+// rotation.
+func issueSessionTokens(r *http.Request, tokenStore db.TokenStore, authenticator auth.Authenticator, user *db.User, family string, deviceName string) (*tokens.Token, *tokens.Token, error) {
+	if family == "" {
+		var err error
+		family, err = tokens.NewFamily()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	authToken, err := authenticator.NewAccessToken(user, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := tokens.GenerateToken(user.ID, tokens.RefreshTTL, tokens.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*tokens.Token{authToken, refreshToken} {
+		token.Family = family
+		token.UserAgent = r.UserAgent()
+		token.IP = utils.ClientIP(r)
+		token.DeviceName = deviceName
+	}
+
+	err = tokenStore.Insert(r.Context(), authToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tokenStore.Insert(r.Context(), refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authToken, refreshToken, nil
+}
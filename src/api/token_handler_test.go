@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/auth"
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/tokens"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+func newTestTokenHandler(tokenStore *memTokenStore, userStore *memUserStore) *TokenHandler {
+	return NewTokenHandler(
+		tokenStore,
+		userStore,
+		nil,
+		auth.NewOpaqueTokenAuthenticator(userStore, tokenStore, discardLogger),
+		nil,
+		nil,
+		plainHasher{},
+		middleware.SessionCookies{Secure: true},
+		NewSecurityLog(&memSecurityEventStore{}, discardLogger),
+		discardLogger,
+	)
+}
+
+func TestDeleteAllTokensEndsRefresh(t *testing.T) {
+	user := &db.User{ID: 1, Username: "mover", Email: "mover@example.com", Activated: true, Role: db.RoleUser}
+	tokenStore := &memTokenStore{}
+	userStore := &memUserStore{users: map[int]*db.User{user.ID: user}}
+	th := newTestTokenHandler(tokenStore, userStore)
+
+	refresh := func(refreshToken string) int {
+		req := httptest.NewRequest(http.MethodPost, "/tokens/refresh", strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+		rec := httptest.NewRecorder()
+		th.HandleRefreshToken(rec, req)
+		return rec.Code
+	}
+
+	var refreshTokens []string
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/tokens/authentication", nil)
+		_, refreshToken, err := issueSessionTokens(req, tokenStore, th.authenticator, user, "", "laptop")
+		require.NoError(t, err)
+		refreshTokens = append(refreshTokens, refreshToken.Plaintext)
+	}
+
+	// A session refreshes as long as it has not been signed out
+	require.Equal(t, http.StatusCreated, refresh(refreshTokens[0]))
+
+	req := httptest.NewRequest(http.MethodDelete, "/tokens", nil)
+	req = middleware.SetUser(req, user)
+	rec := httptest.NewRecorder()
+	th.HandleDeleteAllTokens(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Zero(t, tokenStore.count(user.ID, tokens.ScopeAuth))
+	assert.Zero(t, tokenStore.count(user.ID, tokens.ScopeRefresh))
+	assert.Equal(t, http.StatusUnauthorized, refresh(refreshTokens[1]), "a signed-out device must not refresh back in")
+}
+
+func TestWriteSessionTokens(t *testing.T) {
+	authToken := &tokens.Token{Plaintext: "auth", Expiry: time.Now().Add(time.Hour)}
+	refreshToken := &tokens.Token{Plaintext: "refresh", Expiry: time.Now().Add(24 * time.Hour)}
+	user := &db.User{ID: 1, Username: "mover"}
+
+	t.Run("Tokens in the body", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		writeSessionTokens(rec, http.StatusOK, utils.Envelope{"user": user}, middleware.SessionCookies{Secure: true}, false, authToken, refreshToken, discardLogger, "test")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Result().Cookies())
+		body := rec.Body.String()
+		assert.Contains(t, body, `"user"`)
+		assert.Contains(t, body, `"auth_token"`)
+		assert.Contains(t, body, `"refresh_token"`)
+	})
+
+	t.Run("Browser session", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		writeSessionTokens(rec, http.StatusCreated, utils.Envelope{"user": user}, middleware.SessionCookies{Secure: true}, true, authToken, refreshToken, discardLogger, "test")
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Len(t, rec.Result().Cookies(), 3)
+		body := rec.Body.String()
+		assert.Contains(t, body, `"user"`)
+		assert.Contains(t, body, `"csrf_token"`)
+		assert.NotContains(t, body, `"auth_token"`, "the tokens only travel in HttpOnly cookies")
+		assert.NotContains(t, body, `"refresh_token"`)
+	})
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/password"
+	"github.com/trevortippery/moving-checklist/tokens"
+	"github.com/trevortippery/moving-checklist/totp"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+const (
+	// totpIssuer names the account in authenticator apps.
+	totpIssuer = "Moving Checklist"
+
+	recoveryCodeCount = 10
+)
+
+type TwoFactorHandler struct {
+	twoFactorStore db.TwoFactorStore
+	userStore      db.UserStore
+	hasher         password.Hasher
+	securityLog    *SecurityLog
+	logger         *log.Logger
+}
+
+type confirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type disableTOTPRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+func NewTwoFactorHandler(twoFactorStore db.TwoFactorStore, userStore db.UserStore, hasher password.Hasher, securityLog *SecurityLog, logger *log.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorStore: twoFactorStore,
+		userStore:      userStore,
+		hasher:         hasher,
+		securityLog:    securityLog,
+		logger:         logger,
+	}
+}
+
+// HandleEnrollTOTP starts an enrollment. Nothing changes for the user's
+// sign-ins until the enrollment is confirmed with a code from their app.
+func (th *TwoFactorHandler) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleEnrollTOTP"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	existing, err := th.twoFactorStore.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting totp - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to start two-factor enrollment"})
+		return
+	}
+
+	if existing.Enabled() {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		th.logger.Printf("Error in %s: Generating secret - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to start two-factor enrollment"})
+		return
+	}
+
+	err = th.twoFactorStore.CreatePendingTOTP(r.Context(), user.ID, secret)
+	if err != nil {
+		th.logger.Printf("Error in %s: Storing totp - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to start two-factor enrollment"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// HandleConfirmTOTP enables two-factor authentication once the user proves
+// their app is set up, and hands out recovery codes. This is the only time the
+// recovery codes are shown.
+func (th *TwoFactorHandler) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleConfirmTOTP"
+
+	user := middleware.GetUser(r)
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	var input confirmTOTPRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		th.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if strings.TrimSpace(input.Code) == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"code": "code is required",
+		}})
+		return
+	}
+
+	pending, err := th.twoFactorStore.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting totp - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to enable two-factor authentication"})
+		return
+	}
+
+	if pending == nil {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "start two-factor enrollment first"})
+		return
+	}
+
+	if pending.Enabled() {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	step, ok := totp.Validate(pending.Secret, input.Code, time.Now())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"errors": map[string]string{
+			"code": "code is incorrect, check your authenticator app's clock",
+		}})
+		return
+	}
+
+	recoveryCodes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		th.logger.Printf("Error in %s: Generating recovery codes - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to enable two-factor authentication"})
+		return
+	}
+
+	err = th.twoFactorStore.ConfirmTOTP(r.Context(), user.ID, step, recoveryCodes)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("Error in %s: Confirming totp - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to enable two-factor authentication"})
+		return
+	}
+
+	th.securityLog.Record(r, user.ID, db.SecurityEventTwoFactorEnabled, nil)
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"recovery_codes": recoveryCodes})
+}
+
+// HandleDisableTOTP turns two-factor authentication off. Like other security
+// sensitive changes it requires the current password.
+func (th *TwoFactorHandler) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleDisableTOTP"
+
+	authUser := middleware.GetUser(r)
+	if authUser == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	var input disableTOTPRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		th.logger.Printf("Error in %s: Decoding request - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if input.CurrentPassword == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"current_password": "current_password is required to disable two-factor authentication",
+		}})
+		return
+	}
+
+	user, err := th.userStore.GetUserByID(r.Context(), int64(authUser.ID))
+	if err != nil {
+		th.logger.Printf("Error in %s: Getting user by ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to disable two-factor authentication"})
+		return
+	}
+
+	match, _, err := th.hasher.Verify(user.PasswordHash, []byte(input.CurrentPassword))
+	if err != nil {
+		th.logger.Printf("Error in %s: Checking password - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to disable two-factor authentication"})
+		return
+	}
+
+	if !match {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"errors": map[string]string{
+			"current_password": "current password is incorrect",
+		}})
+		return
+	}
+
+	err = th.twoFactorStore.DeleteTOTP(r.Context(), user.ID)
+	if err != nil {
+		th.logger.Printf("Error in %s: Deleting totp - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to disable two-factor authentication"})
+		return
+	}
+
+	th.securityLog.Record(r, user.ID, db.SecurityEventTwoFactorDisabled, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startTwoFactor issues a short-lived 2fa-pending token if the user has
+// two-factor authentication enabled, and returns nil if they do not. The
+// pending token stands in for the password until a code is supplied.
+func startTwoFactor(r *http.Request, tokenStore db.TokenStore, twoFactorStore db.TwoFactorStore, user *db.User, deviceName string) (*tokens.Token, error) {
+	enrollment, err := twoFactorStore.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !enrollment.Enabled() {
+		return nil, nil
+	}
+
+	pending, err := tokens.GenerateToken(user.ID, tokens.TwoFactorTTL, tokens.ScopeTwoFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	pending.UserAgent = r.UserAgent()
+	pending.IP = utils.ClientIP(r)
+	pending.DeviceName = deviceName
+
+	err = tokenStore.Insert(r.Context(), pending)
+	if err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// Each code is accepted once.
+func verifySecondFactor(r *http.Request, twoFactorStore db.TwoFactorStore, userID int, code, recoveryCode string) (bool, error) {
+	enrollment, err := twoFactorStore.GetTOTP(r.Context(), userID)
+	if err != nil || !enrollment.Enabled() {
+		return false, err
+	}
+
+	if code != "" {
+		step, ok := totp.Validate(enrollment.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return twoFactorStore.UseTOTPStep(r.Context(), userID, step)
+	}
+
+	if recoveryCode != "" {
+		return twoFactorStore.UseRecoveryCode(r.Context(), userID, totp.NormalizeRecoveryCode(recoveryCode))
+	}
+
+	return false, nil
+}
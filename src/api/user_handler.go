@@ -1,40 +1,146 @@
 package api
 
 import (
+	"cmp"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/trevortippery/moving-checklist/auth"
 	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/mailer"
 	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/password"
+	"github.com/trevortippery/moving-checklist/tokens"
 	"github.com/trevortippery/moving-checklist/utils"
 )
 
-var emailRegex = regexp.MustCompile(`^[\w\.-]+@[\w\.-]+\.\w{2,}$`)
+var (
+	emailRegex    = regexp.MustCompile(`^[\w\.-]+@[\w\.-]+\.\w{2,}$`)
+	usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
 
 type UserHandler struct {
-	userStore  db.UserStore
-	tokenStore db.TokenStore
-	logger     *log.Logger
+	userStore           db.UserStore
+	tokenStore          db.TokenStore
+	taskStore           db.TaskStore
+	preferencesStore    db.PreferencesStore
+	twoFactorStore      db.TwoFactorStore
+	authenticator       auth.Authenticator
+	mailer              mailer.Mailer
+	passwordPolicy      *password.Policy
+	hasher              password.Hasher
+	securityLog         *SecurityLog
+	deletionGracePeriod time.Duration
+	logger              *log.Logger
 }
 
 type UserRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	Username        string `json:"username"`
+	Email           string `json:"email"`
+	Password        string `json:"password"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type activateUserRequest struct {
+	Token string `json:"token"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
 	Password string `json:"password"`
 }
 
-func NewUserHandler(userStore db.UserStore, tokenStore db.TokenStore, logger *log.Logger) *UserHandler {
+type currentUserResponse struct {
+	ID               int       `json:"id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	Activated        bool      `json:"activated"`
+	Role             string    `json:"role"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+func NewUserHandler(userStore db.UserStore, tokenStore db.TokenStore, taskStore db.TaskStore, preferencesStore db.PreferencesStore, twoFactorStore db.TwoFactorStore, authenticator auth.Authenticator, mailer mailer.Mailer, passwordPolicy *password.Policy, hasher password.Hasher, securityLog *SecurityLog, deletionGracePeriod time.Duration, logger *log.Logger) *UserHandler {
 	return &UserHandler{
-		userStore:  userStore,
-		tokenStore: tokenStore,
-		logger:     logger,
+		userStore:           userStore,
+		tokenStore:          tokenStore,
+		taskStore:           taskStore,
+		preferencesStore:    preferencesStore,
+		twoFactorStore:      twoFactorStore,
+		authenticator:       authenticator,
+		mailer:              mailer,
+		passwordPolicy:      passwordPolicy,
+		hasher:              hasher,
+		securityLog:         securityLog,
+		deletionGracePeriod: deletionGracePeriod,
+		logger:              logger,
 	}
 }
 
+// HandleGetCurrentUser returns the signed-in user's profile along with a
+// summary of their checklist.
+func (uh *UserHandler) HandleGetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleGetCurrentUser"
+
+	authUser := middleware.GetUser(r)
+	if authUser == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
+		return
+	}
+
+	// Users resolved from JWT claims only carry the basics, so read the
+	// stored record for the rest of the profile
+	user, err := uh.userStore.GetUserByID(r.Context(), int64(authUser.ID))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Getting user by ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	enrollment, err := uh.twoFactorStore.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Getting two-factor status - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	loc, err := userLocation(r.Context(), uh.preferencesStore, user.ID)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Getting time zone - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	counts, err := uh.taskStore.CountTasksForUser(r.Context(), user.ID, time.Now().In(loc))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Counting tasks - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "could not retrieve user"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"user": currentUserResponse{
+			ID:               user.ID,
+			Username:         user.Username,
+			Email:            user.Email,
+			Activated:        user.Activated,
+			Role:             user.Role,
+			TwoFactorEnabled: enrollment.Enabled(),
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+		},
+		"tasks": counts,
+	})
+}
+
 func (uh *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request) {
 	var input UserRequest
 	const funcName = "HandleRegisterUser"
@@ -47,13 +153,32 @@ func (uh *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request
 	}
 
 	validationErrors := validateUserInput(input, ValidateCreate)
+	err = uh.checkPassword(validationErrors, input.Password, input.Username, input.Email)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Checking password policy - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+		return
+	}
+
 	if len(validationErrors) > 0 {
 		uh.logger.Printf("Error in %s: Validating input - %+v", funcName, validationErrors)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": validationErrors})
 		return
 	}
 
-	hashedPassword, err := utils.HashPassword([]byte(input.Password))
+	conflicts, err := uh.findConflicts(r, input.Username, input.Email)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Checking for existing user - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create user"})
+		return
+	}
+
+	if len(conflicts) > 0 {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"errors": conflicts})
+		return
+	}
+
+	hashedPassword, err := uh.hasher.Hash([]byte(input.Password))
 	if err != nil {
 		uh.logger.Printf("Error in %s: Hashing password - %v", funcName, err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
@@ -63,17 +188,39 @@ func (uh *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request
 	user := db.User{
 		Username:     input.Username,
 		Email:        input.Email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
+		Activated:    false,
 	}
 
 	createdUser, err := uh.userStore.RegisterUser(r.Context(), &user)
+	if conflicts := conflictErrors(err); conflicts != nil {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"errors": conflicts})
+		return
+	}
+
 	if err != nil {
 		uh.logger.Printf("Error in %s: Register user - %v", funcName, err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create user"})
 		return
 	}
 
-	token, err := uh.tokenStore.GenerateToken(r.Context(), int64(createdUser.ID), 24*time.Hour, "auth")
+	activationToken, err := uh.tokenStore.GenerateToken(r.Context(), int64(createdUser.ID), tokens.ActivationTTL, tokens.ScopeActivation)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Generating activation token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to generate token"})
+		return
+	}
+
+	uh.securityLog.Record(r, createdUser.ID, db.SecurityEventRegistered, map[string]any{"method": "password"})
+
+	// The account exists at this point, so a delivery failure is logged rather
+	// than failing the registration.
+	err = uh.mailer.Send(r.Context(), mailer.ActivationMessage(createdUser.Email, createdUser.Username, activationToken.Plaintext))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Sending activation email - %v", funcName, err)
+	}
+
+	token, refreshToken, err := issueSessionTokens(r, uh.tokenStore, uh.authenticator, createdUser, "", "")
 	if err != nil {
 		uh.logger.Printf("Error in %s: Generating token - %v", funcName, err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to generate token"})
@@ -82,16 +229,156 @@ func (uh *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request
 
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
 		"user": map[string]interface{}{
-			"id":         createdUser.ID,
-			"username":   createdUser.Username,
-			"email":      createdUser.Email,
-			"created_at": createdUser.CreatedAt,
-			"updated_at": createdUser.UpdatedAt,
-			"token":      token,
+			"id":            createdUser.ID,
+			"username":      createdUser.Username,
+			"email":         createdUser.Email,
+			"activated":     createdUser.Activated,
+			"created_at":    createdUser.CreatedAt,
+			"updated_at":    createdUser.UpdatedAt,
+			"token":         token,
+			"refresh_token": refreshToken,
+		},
+	})
+}
+
+func (uh *UserHandler) HandleActivateUser(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleActivateUser"
+
+	var input activateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Decoding input - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if strings.TrimSpace(input.Token) == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"token": "token is required",
+		}})
+		return
+	}
+
+	user, err := uh.userStore.GetUserByToken(r.Context(), input.Token, tokens.ScopeActivation)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Get user by token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to activate user"})
+		return
+	}
+
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"errors": map[string]string{
+			"token": "invalid or expired activation token",
+		}})
+		return
+	}
+
+	user.Activated = true
+	err = uh.userStore.UpdateUser(r.Context(), user, nil)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Updating user - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to activate user"})
+		return
+	}
+
+	_, err = uh.tokenStore.DeleteAllTokensForUser(r.Context(), int64(user.ID), tokens.ScopeActivation)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Deleting activation tokens - %v", funcName, err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"user": map[string]interface{}{
+			"id":        user.ID,
+			"username":  user.Username,
+			"email":     user.Email,
+			"activated": user.Activated,
 		},
 	})
 }
 
+func (uh *UserHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleResetPassword"
+
+	var input resetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Decoding input - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	validationErrors := make(map[string]string)
+	if strings.TrimSpace(input.Token) == "" {
+		validationErrors["token"] = "token is required"
+	}
+	if strings.TrimSpace(input.Password) == "" {
+		validationErrors["password"] = "password is required"
+	}
+	if len(validationErrors) > 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": validationErrors})
+		return
+	}
+
+	user, err := uh.userStore.GetUserByToken(r.Context(), input.Token, tokens.ScopePasswordReset)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Get user by token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to reset password"})
+		return
+	}
+
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"errors": map[string]string{
+			"token": "invalid or expired password reset token",
+		}})
+		return
+	}
+
+	// The token is left unused if the password is refused, so the user can
+	// try again with another one
+	err = uh.checkPassword(validationErrors, input.Password, user.Username, user.Email)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Checking password policy - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to reset password"})
+		return
+	}
+
+	if len(validationErrors) > 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": validationErrors})
+		return
+	}
+
+	hashedPassword, err := uh.hasher.Hash([]byte(input.Password))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Hashing password - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to reset password"})
+		return
+	}
+
+	// Another request may have used the token since it was looked up
+	err = uh.userStore.ResetPassword(r.Context(), input.Token, hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"errors": map[string]string{
+			"token": "invalid or expired password reset token",
+		}})
+		return
+	}
+
+	if err != nil {
+		uh.logger.Printf("Error in %s: Resetting password - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to reset password"})
+		return
+	}
+
+	uh.securityLog.Record(r, user.ID, db.SecurityEventPasswordChanged, map[string]any{"method": "reset"})
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"message": "password reset successfully",
+	})
+}
+
+// HandleDeleteUser deletes the account but keeps it restorable for the grace
+// period. The restore token is returned and also emailed, since every other
+// token the user held stops working.
 func (uh *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	const funcName = "HandleDeleteUser"
 
@@ -101,90 +388,397 @@ func (uh *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := uh.userStore.DeleteUser(r.Context(), int64(user.ID))
+	restoreToken, err := tokens.GenerateToken(user.ID, uh.deletionGracePeriod, tokens.ScopeRestore)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Generating restore token - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to delete user"})
+		return
+	}
+
+	err = uh.userStore.SoftDeleteUser(r.Context(), int64(user.ID), restoreToken)
 	if err != nil {
 		uh.logger.Printf("Error in %s: Delete user - %v", funcName, err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to delete user"})
 		return
 	}
 
+	uh.securityLog.Record(r, user.ID, db.SecurityEventAccountDeleted, nil)
+
+	err = uh.mailer.Send(r.Context(), mailer.AccountDeletedMessage(user.Email, user.Username, restoreToken.Plaintext, restoreToken.Expiry))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Sending deletion email - %v", funcName, err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"message":       "user deleted successfully",
+		"restore_token": restoreToken,
+	})
+}
+
+// HandleRestoreUser brings back a deleted account and its tasks. The user
+// signs in again afterwards, as all their sessions were revoked.
+func (uh *UserHandler) HandleRestoreUser(w http.ResponseWriter, r *http.Request) {
+	const funcName = "HandleRestoreUser"
+
+	var input activateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Decoding input - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if strings.TrimSpace(input.Token) == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+			"token": "token is required",
+		}})
+		return
+	}
+
+	user, err := uh.userStore.RestoreUser(r.Context(), input.Token)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Restoring user - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to restore user"})
+		return
+	}
+
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"errors": map[string]string{
+			"token": "invalid or expired restore token",
+		}})
+		return
+	}
+
+	uh.securityLog.Record(r, user.ID, db.SecurityEventAccountRestored, nil)
+
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
-		"message": "user deleted successfully",
+		"user": map[string]interface{}{
+			"id":        user.ID,
+			"username":  user.Username,
+			"email":     user.Email,
+			"activated": user.Activated,
+		},
 	})
 }
 
+// HandleUpdateUser applies a partial update: only the fields present in the
+// request body change.
 func (uh *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	const funcName = "HandleUpdateUser"
 
-	user := middleware.GetUser(r)
-	if user == nil {
+	authUser := middleware.GetUser(r)
+	if authUser == nil {
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "not authenticated"})
 		return
 	}
 
-	var input UserRequest
-	err := json.NewDecoder(r.Body).Decode(&input)
+	var updateUserRequest struct {
+		Username        *string `json:"username"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&updateUserRequest)
 	if err != nil {
 		uh.logger.Printf("Error in %s: Decoding input - %v", funcName, err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
 		return
 	}
 
-	validationErrors := validateUserInput(input, ValidateUpdate)
+	validationErrors := make(map[string]string)
+	if updateUserRequest.Username != nil {
+		if msg := validateUsername(*updateUserRequest.Username); msg != "" {
+			validationErrors["username"] = msg
+		}
+	}
+	if updateUserRequest.Email != nil {
+		if msg := validateEmail(*updateUserRequest.Email); msg != "" {
+			validationErrors["email"] = msg
+		}
+	}
+
+	// Users resolved from JWT claims carry no password hash, so always work
+	// from the stored record
+	user, err := uh.userStore.GetUserByID(r.Context(), int64(authUser.ID))
+	if err != nil {
+		uh.logger.Printf("Error in %s: Getting user by ID - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
+		return
+	}
+
+	var newUsername, newEmail string
+	if updateUserRequest.Username != nil && *updateUserRequest.Username != user.Username {
+		newUsername = *updateUserRequest.Username
+	}
+	if updateUserRequest.Email != nil && *updateUserRequest.Email != user.Email {
+		newEmail = *updateUserRequest.Email
+	}
+	passwordChanged := updateUserRequest.Password != nil
+
+	// The password is checked against the username and email the user will
+	// have after the update
+	if passwordChanged {
+		err = uh.checkPassword(validationErrors, *updateUserRequest.Password, cmp.Or(newUsername, user.Username), cmp.Or(newEmail, user.Email))
+		if err != nil {
+			uh.logger.Printf("Error in %s: Checking password policy - %v", funcName, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
+			return
+		}
+	}
+
 	if len(validationErrors) > 0 {
 		uh.logger.Printf("Error in %s: Validation - %+v", funcName, validationErrors)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": validationErrors})
 		return
 	}
 
-	// Update allowed fields
-	user.Username = input.Username
-	user.Email = input.Email
+	// Changing the email or password requires proving knowledge of the current
+	// password, so a leaked token alone cannot take over the account
+	if passwordChanged || newEmail != "" {
+		if updateUserRequest.CurrentPassword == "" {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"errors": map[string]string{
+				"current_password": "current_password is required to change email or password",
+			}})
+			return
+		}
+
+		match, _, err := uh.hasher.Verify(user.PasswordHash, []byte(updateUserRequest.CurrentPassword))
+		if err != nil {
+			uh.logger.Printf("Error in %s: Checking password - %v", funcName, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
+			return
+		}
+
+		if !match {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"errors": map[string]string{
+				"current_password": "current password is incorrect",
+			}})
+			return
+		}
+	}
+
+	// Uniqueness ignores case, so a change of case alone cannot clash with
+	// another account, only with this one
+	checkUsername, checkEmail := newUsername, newEmail
+	if strings.EqualFold(checkUsername, user.Username) {
+		checkUsername = ""
+	}
+	if strings.EqualFold(checkEmail, user.Email) {
+		checkEmail = ""
+	}
+
+	conflicts, err := uh.findConflicts(r, checkUsername, checkEmail)
+	if err != nil {
+		uh.logger.Printf("Error in %s: Checking for existing user - %v", funcName, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
+		return
+	}
+
+	if len(conflicts) > 0 {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"errors": conflicts})
+		return
+	}
+
+	oldUsername, oldEmail := user.Username, user.Email
+	if newUsername != "" {
+		user.Username = newUsername
+	}
+
+	if newEmail != "" {
+		user.Email = newEmail
+	}
 
-	// Only hash password if it's being updated
-	if input.Password != "" {
-		hashedPassword, err := utils.HashPassword([]byte(input.Password))
+	// A new address has to be verified again. A change of case alone is
+	// still the same mailbox
+	var activationToken *tokens.Token
+	if checkEmail != "" {
+		activationToken, err = tokens.GenerateToken(user.ID, tokens.ActivationTTL, tokens.ScopeActivation)
+		if err != nil {
+			uh.logger.Printf("Error in %s: Generating activation token - %v", funcName, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
+			return
+		}
+		user.Activated = false
+	}
+
+	if passwordChanged {
+		hashedPassword, err := uh.hasher.Hash([]byte(*updateUserRequest.Password))
 		if err != nil {
 			uh.logger.Printf("Error in %s: Hashing password - %v", funcName, err)
 			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
 			return
 		}
-		user.PasswordHash = string(hashedPassword)
+		user.PasswordHash = hashedPassword
+	}
+
+	err = uh.userStore.UpdateUser(r.Context(), user, activationToken)
+
+	// Another request can claim the name between the check and the update
+	if conflicts := conflictErrors(err); conflicts != nil {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"errors": conflicts})
+		return
 	}
 
-	err = uh.userStore.UpdateUser(r.Context(), user)
 	if err != nil {
 		uh.logger.Printf("Error in %s: Updating user - %v", funcName, err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
 		return
 	}
 
+	if newUsername != "" {
+		uh.securityLog.Record(r, user.ID, db.SecurityEventUsernameChanged, map[string]any{"from": oldUsername, "to": newUsername})
+	}
+	if newEmail != "" {
+		uh.securityLog.Record(r, user.ID, db.SecurityEventEmailChanged, map[string]any{"from": oldEmail, "to": newEmail})
+	}
+	if passwordChanged {
+		uh.securityLog.Record(r, user.ID, db.SecurityEventPasswordChanged, map[string]any{"method": "update"})
+	}
+
+	if activationToken != nil {
+		err = uh.mailer.Send(r.Context(), mailer.ActivationMessage(user.Email, user.Username, activationToken.Plaintext))
+		if err != nil {
+			uh.logger.Printf("Error in %s: Sending activation email - %v", funcName, err)
+		}
+	}
+
+	if passwordChanged {
+		err = uh.revokeOtherSessions(r, user)
+		if err != nil {
+			uh.logger.Printf("Error in %s: Revoking other sessions - %v", funcName, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "password updated but failed to sign out other sessions"})
+			return
+		}
+	}
+
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
 		"user": map[string]interface{}{
 			"id":         user.ID,
 			"username":   user.Username,
 			"email":      user.Email,
+			"activated":  user.Activated,
 			"updated_at": time.Now().UTC(),
 		},
 	})
 }
 
+// checkPassword applies the password policy for a user with the given
+// username and email, adding any problem to validationErrors.
+func (uh *UserHandler) checkPassword(validationErrors map[string]string, password, username, email string) error {
+	msg, err := uh.passwordPolicy.Check(password, username, email)
+	if err != nil {
+		return err
+	}
+
+	if msg != "" {
+		validationErrors["password"] = msg
+	}
+	return nil
+}
+
+// findConflicts reports which of username and email already belong to an
+// account, ignoring case. Empty values are not checked.
+func (uh *UserHandler) findConflicts(r *http.Request, username, email string) (map[string]string, error) {
+	conflicts := make(map[string]string)
+
+	if username != "" {
+		exists, err := uh.userStore.CheckUsernameExists(r.Context(), username)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			conflicts["username"] = "username is already taken"
+		}
+	}
+
+	if email != "" {
+		exists, err := uh.userStore.CheckEmailExists(r.Context(), email)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			conflicts["email"] = "an account with this email already exists"
+		}
+	}
+
+	return conflicts, nil
+}
+
+// conflictErrors turns a store uniqueness error into field errors, or returns
+// nil for any other error.
+func conflictErrors(err error) map[string]string {
+	switch {
+	case errors.Is(err, db.ErrDuplicateUsername):
+		return map[string]string{"username": "username is already taken"}
+	case errors.Is(err, db.ErrDuplicateEmail):
+		return map[string]string{"email": "an account with this email already exists"}
+	}
+	return nil
+}
+
+// revokeOtherSessions signs the user out everywhere except the session the
+// request was made with.
+func (uh *UserHandler) revokeOtherSessions(r *http.Request, user *db.User) error {
+	current, err := uh.tokenStore.GetToken(r.Context(), middleware.GetToken(r), tokens.ScopeAuth)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		current = &tokens.Token{}
+	}
+
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh} {
+		_, err = uh.tokenStore.DeleteOtherTokensForUser(r.Context(), int64(user.ID), scope, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = uh.tokenStore.DeleteAllTokensForUser(r.Context(), int64(user.ID), tokens.ScopePasswordReset)
+	return err
+}
+
 func validateUserInput(input UserRequest, mode ValidationMode) map[string]string {
 	errors := make(map[string]string)
 
 	if mode == ValidateCreate {
-		if strings.TrimSpace(input.Username) == "" {
-			errors["username"] = "username is required"
-		} else if len(input.Username) > 50 {
-			errors["username"] = "username must be less than 50 characters"
+		if msg := validateUsername(input.Username); msg != "" {
+			errors["username"] = msg
 		}
 
-		if strings.TrimSpace(input.Password) == "" {
-			errors["password"] = "password is required"
-		} else if len(input.Password) < 8 {
-			errors["password"] = "password must be at least 8 characters"
+		if msg := validateEmail(input.Email); msg != "" {
+			errors["email"] = msg
 		}
 	}
 
 	return errors
 }
+
+func validateUsername(username string) string {
+	if strings.TrimSpace(username) == "" {
+		return "username is required"
+	}
+	if utf8.RuneCountInString(username) > 50 {
+		return "username must be at most 50 characters"
+	}
+	if !usernameRegex.MatchString(username) {
+		return "username may only contain letters, numbers, dots, dashes and underscores"
+	}
+	return ""
+}
+
+func validateEmail(email string) string {
+	if strings.TrimSpace(email) == "" {
+		return "email is required"
+	}
+	if len(email) > 255 {
+		return "email must be less than 255 characters"
+	}
+	if !emailRegex.MatchString(email) {
+		return "email must be a valid email address"
+	}
+	return ""
+}
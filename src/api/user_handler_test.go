@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+func TestUpdateUserEmailNeedsActivation(t *testing.T) {
+	tests := []struct {
+		name          string
+		email         string
+		wantActivated bool
+	}{
+		{"New address", "new@example.com", false},
+		{"Change of case", "Mover@Example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &db.User{ID: 1, Username: "mover", Email: "mover@example.com", PasswordHash: "plain:secret", Activated: true}
+			tokenStore := &memTokenStore{}
+			userStore := &memUserStore{users: map[int]*db.User{user.ID: user}, tokens: tokenStore}
+			mail := &memMailer{}
+			uh := NewUserHandler(userStore, tokenStore, nil, nil, nil, nil, mail, nil, plainHasher{}, NewSecurityLog(&memSecurityEventStore{}, discardLogger), time.Hour, discardLogger)
+
+			// Left over from registration, sent to the old address
+			_, err := tokenStore.GenerateToken(t.Context(), int64(user.ID), tokens.ActivationTTL, tokens.ScopeActivation)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPut, "/users/me", strings.NewReader(`{"email":"`+tt.email+`","current_password":"secret"}`))
+			req = middleware.SetUser(req, user)
+			rec := httptest.NewRecorder()
+			uh.HandleUpdateUser(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			stored, err := userStore.GetUserByID(t.Context(), int64(user.ID))
+			require.NoError(t, err)
+			assert.Equal(t, tt.email, stored.Email)
+			assert.Equal(t, tt.wantActivated, stored.Activated)
+
+			if tt.wantActivated {
+				assert.Empty(t, mail.messages)
+				return
+			}
+
+			assert.Equal(t, 1, tokenStore.count(user.ID, tokens.ScopeActivation), "the old address's token no longer works")
+			require.Len(t, mail.messages, 1)
+			assert.Equal(t, tt.email, mail.messages[0].To)
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	assert.Empty(t, validateUsername(strings.Repeat("a", 50)))
+	assert.Equal(t, "username must be at most 50 characters", validateUsername(strings.Repeat("a", 51)))
+	assert.Equal(t, "username is required", validateUsername(" "))
+	assert.NotEmpty(t, validateUsername("mover!"))
+}
@@ -1,25 +1,51 @@
 package app
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/trevortippery/moving-checklist/api"
+	"github.com/trevortippery/moving-checklist/auth"
 	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/lockout"
+	"github.com/trevortippery/moving-checklist/mailer"
+	"github.com/trevortippery/moving-checklist/maintenance"
 	"github.com/trevortippery/moving-checklist/middleware"
 	"github.com/trevortippery/moving-checklist/migrations"
+	"github.com/trevortippery/moving-checklist/oidc"
+	"github.com/trevortippery/moving-checklist/password"
 )
 
 type Application struct {
-	Logger      *log.Logger
-	TaskHandler *api.TaskHandler
-	UserHandler *api.UserHandler
-	Middleware  *middleware.AuthMiddleware
-	DB          *sql.DB
+	Config             Config
+	Logger             *log.Logger
+	TaskHandler        *api.TaskHandler
+	UserHandler        *api.UserHandler
+	TokenHandler       *api.TokenHandler
+	SessionHandler     *api.SessionHandler
+	APIKeyHandler      *api.APIKeyHandler
+	OIDCHandler        *api.OIDCHandler
+	TwoFactorHandler   *api.TwoFactorHandler
+	AdminHandler       *api.AdminHandler
+	PreferencesHandler *api.PreferencesHandler
+	Middleware         *middleware.AuthMiddleware
+	TrustedProxies     []netip.Prefix
+	Maintenance        *maintenance.Runner
+	DB                 *sql.DB
 }
 
-func NewApplication() (*Application, error) {
+func NewApplication(cfg Config) (*Application, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	database, err := db.Open()
 	if err != nil {
 		return nil, err
@@ -35,18 +61,220 @@ func NewApplication() (*Application, error) {
 	taskStore := db.NewPostgresTaskStore(database)
 	userStore := db.NewPostgresUserStore(database)
 	tokenStore := db.NewPostgresTokenStore(database)
+	outboxStore := db.NewPostgresOutboxStore(database)
+	apiKeyStore := db.NewPostgresAPIKeyStore(database)
+	identityStore := db.NewPostgresIdentityStore(database)
+	twoFactorStore := db.NewPostgresTwoFactorStore(database)
+	adminStore := db.NewPostgresAdminStore(database)
+	securityEventStore := db.NewPostgresSecurityEventStore(database)
+	preferencesStore := db.NewPostgresPreferencesStore(database)
+
+	var appMailer mailer.Mailer
+	if cfg.SMTPHost != "" {
+		appMailer = mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPSender)
+	} else {
+		appMailer = mailer.NewOutboxMailer(outboxStore, logger)
+	}
+
+	authenticator, err := newAuthenticator(cfg, userStore, tokenStore, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	attemptStore, err := newAttemptStore(cfg, database)
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutPolicy := func(threshold int) lockout.Policy {
+		return lockout.Policy{
+			Threshold: threshold,
+			BaseDelay: cfg.LockoutBaseDelay,
+			MaxDelay:  cfg.LockoutMaxDelay,
+			Window:    cfg.LockoutWindow,
+		}
+	}
+	loginThrottle := api.NewLoginThrottle(
+		lockout.NewLimiter(attemptStore, "account:", lockoutPolicy(cfg.LockoutAccountThreshold)),
+		lockout.NewLimiter(attemptStore, "login-ip:", lockoutPolicy(cfg.LockoutIPThreshold)),
+		logger,
+	)
+	tokenLimiter := lockout.NewLimiter(attemptStore, "token-ip:", lockoutPolicy(cfg.LockoutTokenThreshold))
+	oidcLimiter := lockout.NewLimiter(attemptStore, "oidc-ip:", lockoutPolicy(cfg.LockoutOIDCThreshold))
+
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	taskHandler := api.NewTaskHandler(taskStore, logger)
-	userHandler := api.NewUserHandler(userStore, tokenStore, logger)
-	middlewareHandler := &middleware.AuthMiddleware{UserStore: userStore}
+	passwordHasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	securityLog := api.NewSecurityLog(securityEventStore, logger)
+
+	taskHandler := api.NewTaskHandler(taskStore, preferencesStore, logger)
+	userHandler := api.NewUserHandler(userStore, tokenStore, taskStore, preferencesStore, twoFactorStore, authenticator, appMailer, passwordPolicy, passwordHasher, securityLog, cfg.DeletionGracePeriod, logger)
+	tokenHandler := api.NewTokenHandler(tokenStore, userStore, twoFactorStore, authenticator, loginThrottle, appMailer, passwordHasher, middleware.SessionCookies{Secure: cfg.CookieSecure}, securityLog, logger)
+	sessionHandler := api.NewSessionHandler(tokenStore, securityLog, logger)
+	apiKeyHandler := api.NewAPIKeyHandler(apiKeyStore, securityLog, logger)
+	twoFactorHandler := api.NewTwoFactorHandler(twoFactorStore, userStore, passwordHasher, securityLog, logger)
+	preferencesHandler := api.NewPreferencesHandler(preferencesStore, logger)
+	maintenanceRunner := newMaintenanceRunner(cfg, database, tokenStore, userStore, identityStore, attemptStore, logger)
+
+	adminHandler := api.NewAdminHandler(adminStore, userStore, taskStore, preferencesStore, maintenanceRunner, securityLog, logger)
+	var oidcClient *oidc.Client
+	if cfg.OIDCIssuerURL != "" {
+		oidcClient = oidc.NewClient(oidc.Config{
+			IssuerURL:    cfg.OIDCIssuerURL,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+		}, nil)
+	}
+	oidcHandler := api.NewOIDCHandler(oidcClient, identityStore, userStore, tokenStore, twoFactorStore, authenticator, passwordHasher, oidcLimiter, middleware.SessionCookies{Secure: cfg.CookieSecure}, securityLog, logger)
+	middlewareHandler := middleware.NewAuthMiddleware(authenticator, apiKeyStore, tokenLimiter, logger)
+
+	// Already checked by Validate
+	trustedProxies, _ := middleware.ParseTrustedProxies(cfg.TrustedProxies)
 
 	app := &Application{
-		Logger:      logger,
-		TaskHandler: taskHandler,
-		UserHandler: userHandler,
-		Middleware:  middlewareHandler,
-		DB:          database,
+		Config:             cfg,
+		Logger:             logger,
+		TaskHandler:        taskHandler,
+		UserHandler:        userHandler,
+		TokenHandler:       tokenHandler,
+		SessionHandler:     sessionHandler,
+		APIKeyHandler:      apiKeyHandler,
+		OIDCHandler:        oidcHandler,
+		TwoFactorHandler:   twoFactorHandler,
+		AdminHandler:       adminHandler,
+		PreferencesHandler: preferencesHandler,
+		Middleware:         middlewareHandler,
+		TrustedProxies:     trustedProxies,
+		Maintenance:        maintenanceRunner,
+		DB:                 database,
 	}
 
+	maintenanceRunner.Start()
+
 	return app, nil
 }
+
+// Close stops the maintenance jobs and closes the database.
+func (app *Application) Close() error {
+	app.Maintenance.Stop()
+	return app.DB.Close()
+}
+
+func newAuthenticator(cfg Config, userStore db.UserStore, tokenStore db.TokenStore, logger *log.Logger) (auth.Authenticator, error) {
+	switch cfg.AuthStrategy {
+	case "", "opaque":
+		return auth.NewOpaqueTokenAuthenticator(userStore, tokenStore, logger), nil
+	case "jwt":
+		keys, err := auth.ParseKeys(cfg.JWTAlgorithm, cfg.JWTKeys)
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewJWTAuthenticator(userStore, auth.JWTConfig{
+			Issuer: cfg.JWTIssuer,
+			TTL:    cfg.JWTTTL,
+			Keys:   keys,
+		})
+	default:
+		return nil, fmt.Errorf("app: unknown auth strategy %q", cfg.AuthStrategy)
+	}
+}
+
+func newPasswordPolicy(cfg Config) (*password.Policy, error) {
+	if cfg.PasswordMaxLength < 1 || cfg.PasswordMaxLength > password.MaxBytes {
+		return nil, fmt.Errorf("app: password max length must be between 1 and %d bytes", password.MaxBytes)
+	}
+	if cfg.PasswordMinLength > cfg.PasswordMaxLength {
+		return nil, fmt.Errorf("app: password min length %d is above the max length %d", cfg.PasswordMinLength, cfg.PasswordMaxLength)
+	}
+
+	policy := &password.Policy{
+		MinLength: cfg.PasswordMinLength,
+		MaxLength: cfg.PasswordMaxLength,
+	}
+
+	if cfg.PasswordBreachedList != "" {
+		breached, err := password.OpenBreachedList(cfg.PasswordBreachedList)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+func newPasswordHasher(cfg Config) (password.Hasher, error) {
+	if cfg.PasswordArgon2Memory < 1 || cfg.PasswordArgon2Iterations < 1 {
+		return nil, fmt.Errorf("app: argon2 memory and iterations must be positive")
+	}
+	if cfg.PasswordArgon2Parallelism < 1 || cfg.PasswordArgon2Parallelism > 255 {
+		return nil, fmt.Errorf("app: argon2 parallelism must be between 1 and 255")
+	}
+
+	params := password.DefaultArgon2idParams
+	params.Memory = uint32(cfg.PasswordArgon2Memory)
+	params.Iterations = uint32(cfg.PasswordArgon2Iterations)
+	params.Parallelism = uint8(cfg.PasswordArgon2Parallelism)
+
+	return password.NewArgon2idHasher(params)
+}
+
+// newMaintenanceRunner sets up the cleanup jobs. Counters kept in memory clean
+// up after themselves, so only the Postgres attempt store gets a job.
+func newMaintenanceRunner(cfg Config, database *sql.DB, tokenStore db.TokenStore, userStore db.UserStore, identityStore db.IdentityStore, attemptStore lockout.Store, logger *log.Logger) *maintenance.Runner {
+	runner := maintenance.NewRunner(db.NewPostgresAdvisoryLocker(database), logger)
+
+	runner.Add(maintenance.Job{
+		Name:     "expired-tokens",
+		Interval: cfg.SweepInterval,
+		Run:      maintenance.Batched(cfg.SweepBatchSize, tokenStore.DeleteExpired),
+	})
+
+	runner.Add(maintenance.Job{
+		Name:     "expired-login-states",
+		Interval: cfg.SweepInterval,
+		Run:      maintenance.Batched(cfg.SweepBatchSize, identityStore.DeleteExpiredLoginStates),
+	})
+
+	runner.Add(maintenance.Job{
+		Name:     "deleted-users",
+		Interval: cfg.PurgeInterval,
+		Run: func(ctx context.Context) (int64, error) {
+			// The cut-off is fixed for the whole run
+			deletedBefore := time.Now().Add(-cfg.DeletionGracePeriod)
+			return maintenance.Batched(cfg.SweepBatchSize, func(ctx context.Context, limit int) (int64, error) {
+				return userStore.PurgeDeletedUsers(ctx, deletedBefore, limit)
+			})(ctx)
+		},
+	})
+
+	if store, ok := attemptStore.(*db.PostgresAttemptStore); ok {
+		runner.Add(maintenance.Job{
+			Name:     "auth-failures",
+			Interval: cfg.SweepInterval,
+			Run:      maintenance.Batched(cfg.SweepBatchSize, store.DeleteExpired),
+		})
+	}
+
+	return runner
+}
+
+func newAttemptStore(cfg Config, database *sql.DB) (lockout.Store, error) {
+	switch cfg.LockoutStore {
+	case "", "postgres":
+		return db.NewPostgresAttemptStore(database), nil
+	case "memory":
+		return lockout.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("app: unknown lockout store %q", cfg.LockoutStore)
+	}
+}
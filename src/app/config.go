@@ -0,0 +1,187 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/password"
+)
+
+type Config struct {
+	// AuthStrategy selects how access tokens work: "opaque" tokens looked up in
+	// the database on every request, or stateless signed "jwt" tokens.
+	AuthStrategy string
+
+	// JWT settings, only used by the "jwt" strategy. JWTKeys is a comma
+	// separated list of id:base64-key pairs; the first one signs new tokens.
+	JWTAlgorithm string
+	JWTKeys      string
+	JWTIssuer    string
+	JWTTTL       time.Duration
+
+	// OpenID Connect single sign-on, enabled when OIDCIssuerURL is set.
+	// OIDCScopes is space separated and requested in addition to openid.
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       string
+
+	// Brute-force protection. Failed logins are counted per account and per
+	// IP, and invalid bearer tokens and single sign-on starts per IP; a
+	// threshold of 0 turns that
+	// counter off. LockoutStore is "postgres" to share counters between
+	// instances or "memory" for a single instance.
+	LockoutStore            string
+	LockoutAccountThreshold int
+	LockoutIPThreshold      int
+	LockoutTokenThreshold   int
+	LockoutOIDCThreshold    int
+	LockoutBaseDelay        time.Duration
+	LockoutMaxDelay         time.Duration
+	LockoutWindow           time.Duration
+
+	// Password policy applied on registration, profile updates and resets.
+	// PasswordBreachedList is a directory of SHA-1 range files; the breached
+	// password check is off when it is empty.
+	PasswordMinLength    int
+	PasswordMaxLength    int
+	PasswordBreachedList string
+
+	// Argon2id cost for new password hashes. Memory is in KiB. Existing hashes
+	// made with other parameters, or with bcrypt, are replaced on the next
+	// successful login.
+	PasswordArgon2Memory      int
+	PasswordArgon2Iterations  int
+	PasswordArgon2Parallelism int
+
+	// Deleted accounts can be restored for DeletionGracePeriod and are then
+	// purged by a background job that runs every PurgeInterval.
+	DeletionGracePeriod time.Duration
+	PurgeInterval       time.Duration
+
+	// Expired tokens and login failure counters are deleted every
+	// SweepInterval. Every cleanup job, the purge included, deletes at most
+	// SweepBatchSize rows per statement.
+	SweepInterval  time.Duration
+	SweepBatchSize int
+
+	// TrustedProxies is a comma separated list of proxy addresses or CIDR
+	// ranges whose X-Forwarded-For header is believed. Requests from anywhere
+	// else are attributed to the address they came from.
+	TrustedProxies string
+
+	// CookieSecure marks browser session cookies Secure so they are only sent
+	// over HTTPS. Turn it off only for local development over plain HTTP.
+	CookieSecure bool
+
+	// RequireActivation keeps users who have not verified their email out of
+	// the task routes.
+	RequireActivation bool
+
+	// SMTP settings; when SMTPHost is empty email is written to the
+	// outbox_emails table and the log instead of being delivered.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPSender   string
+}
+
+// LoadConfig reads the application configuration from the environment,
+// falling back to defaults suitable for local development.
+func LoadConfig() Config {
+	return Config{
+		AuthStrategy:              envString("AUTH_STRATEGY", "opaque"),
+		JWTAlgorithm:              envString("JWT_ALGORITHM", "HS256"),
+		JWTKeys:                   envString("JWT_KEYS", ""),
+		JWTIssuer:                 envString("JWT_ISSUER", "moving-checklist"),
+		JWTTTL:                    envDuration("JWT_TTL", 15*time.Minute),
+		OIDCIssuerURL:             envString("OIDC_ISSUER_URL", ""),
+		OIDCClientID:              envString("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:          envString("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:           envString("OIDC_REDIRECT_URL", "http://localhost:8080/oidc/callback"),
+		OIDCScopes:                envString("OIDC_SCOPES", "email profile"),
+		LockoutStore:              envString("LOCKOUT_STORE", "postgres"),
+		LockoutAccountThreshold:   envInt("LOCKOUT_ACCOUNT_THRESHOLD", 5),
+		LockoutIPThreshold:        envInt("LOCKOUT_IP_THRESHOLD", 50),
+		LockoutTokenThreshold:     envInt("LOCKOUT_TOKEN_THRESHOLD", 20),
+		LockoutOIDCThreshold:      envInt("LOCKOUT_OIDC_THRESHOLD", 20),
+		LockoutBaseDelay:          envDuration("LOCKOUT_BASE_DELAY", 30*time.Second),
+		LockoutMaxDelay:           envDuration("LOCKOUT_MAX_DELAY", time.Hour),
+		LockoutWindow:             envDuration("LOCKOUT_WINDOW", 15*time.Minute),
+		PasswordMinLength:         envInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:         envInt("PASSWORD_MAX_LENGTH", 128),
+		PasswordBreachedList:      envString("PASSWORD_BREACHED_LIST", ""),
+		PasswordArgon2Memory:      envInt("PASSWORD_ARGON2_MEMORY", int(password.DefaultArgon2idParams.Memory)),
+		PasswordArgon2Iterations:  envInt("PASSWORD_ARGON2_ITERATIONS", int(password.DefaultArgon2idParams.Iterations)),
+		PasswordArgon2Parallelism: envInt("PASSWORD_ARGON2_PARALLELISM", int(password.DefaultArgon2idParams.Parallelism)),
+		DeletionGracePeriod:       envDuration("DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		PurgeInterval:             envDuration("PURGE_INTERVAL", time.Hour),
+		SweepInterval:             envDuration("SWEEP_INTERVAL", 10*time.Minute),
+		SweepBatchSize:            envInt("SWEEP_BATCH_SIZE", 1000),
+		TrustedProxies:            envString("TRUSTED_PROXIES", ""),
+		CookieSecure:              envBool("COOKIE_SECURE", true),
+		RequireActivation:         envBool("REQUIRE_ACTIVATION", false),
+		SMTPHost:                  envString("SMTP_HOST", ""),
+		SMTPPort:                  envInt("SMTP_PORT", 587),
+		SMTPUsername:              envString("SMTP_USERNAME", ""),
+		SMTPPassword:              envString("SMTP_PASSWORD", ""),
+		SMTPSender:                envString("SMTP_SENDER", "Moving Checklist <no-reply@moving-checklist.local>"),
+	}
+}
+
+// Validate rejects settings that would stop the server working once started,
+// such as a job interval that time.NewTicker would panic on, before anything
+// is opened.
+func (cfg Config) Validate() error {
+	if cfg.SweepInterval <= 0 || cfg.PurgeInterval <= 0 {
+		return fmt.Errorf("app: SWEEP_INTERVAL and PURGE_INTERVAL must be positive")
+	}
+	if cfg.SweepBatchSize < 1 {
+		return fmt.Errorf("app: SWEEP_BATCH_SIZE must be at least 1")
+	}
+	if cfg.DeletionGracePeriod <= 0 {
+		return fmt.Errorf("app: DELETION_GRACE_PERIOD must be positive")
+	}
+	_, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("app: TRUSTED_PROXIES: %w", err)
+	}
+	return nil
+}
+
+func envString(key, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		valid  bool
+	}{
+		{"Defaults", func(cfg *Config) {}, true},
+		{"Zero purge interval", func(cfg *Config) { cfg.PurgeInterval = 0 }, false},
+		{"Negative sweep interval", func(cfg *Config) { cfg.SweepInterval = -time.Minute }, false},
+		{"Zero batch size", func(cfg *Config) { cfg.SweepBatchSize = 0 }, false},
+		{"Zero grace period", func(cfg *Config) { cfg.DeletionGracePeriod = 0 }, false},
+		{"Negative grace period", func(cfg *Config) { cfg.DeletionGracePeriod = -time.Hour }, false},
+		{"Trusted proxies", func(cfg *Config) { cfg.TrustedProxies = "10.0.0.0/8,127.0.0.1" }, true},
+		{"Invalid trusted proxy", func(cfg *Config) { cfg.TrustedProxies = "proxy.local" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := LoadConfig()
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+// SessionTouchInterval bounds how often a credential's last_used_at is written.
+const SessionTouchInterval = 5 * time.Minute
+
+// ErrTokenExpired is returned for a token that was genuinely issued but has
+// since expired. Clients are expected to present those now and then, so they
+// are not treated as guesses.
+var ErrTokenExpired = errors.New("auth: token expired")
+
+// Authenticator is a strategy for resolving bearer tokens to users and for
+// minting the access tokens it understands.
+type Authenticator interface {
+	// Authenticate returns the user a bearer token belongs to, or nil if the
+	// token is invalid or revoked, and ErrTokenExpired if it has expired.
+	// Other errors are reserved for failures that say nothing about the token
+	// itself.
+	Authenticate(ctx context.Context, token string) (*db.User, error)
+
+	// NewAccessToken mints an access token for the user as part of the given
+	// token family. The caller is responsible for storing it.
+	NewAccessToken(user *db.User, family string) (*tokens.Token, error)
+}
+
+// Rechecker is implemented by authenticators that trust a token's claims
+// without looking it up. Recheck does look it up, returning the user as stored
+// or nil if the token has been revoked or the account deleted or locked since
+// the token was issued.
+type Rechecker interface {
+	Recheck(ctx context.Context, token string) (*db.User, error)
+}
+
+// OpaqueTokenAuthenticator is the default strategy: random tokens whose hash
+// is looked up in the tokens table on every request.
+type OpaqueTokenAuthenticator struct {
+	userStore  db.UserStore
+	tokenStore db.TokenStore
+	logger     *log.Logger
+}
+
+func NewOpaqueTokenAuthenticator(userStore db.UserStore, tokenStore db.TokenStore, logger *log.Logger) *OpaqueTokenAuthenticator {
+	return &OpaqueTokenAuthenticator{
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		logger:     logger,
+	}
+}
+
+func (oa *OpaqueTokenAuthenticator) Authenticate(ctx context.Context, token string) (*db.User, error) {
+	user, err := oa.userStore.GetUserByToken(ctx, token, tokens.ScopeAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired tokens are only recognised until the sweep deletes them
+	if user == nil {
+		expired, err := oa.tokenStore.TokenExpired(ctx, token, tokens.ScopeAuth)
+		if err != nil || !expired {
+			return nil, err
+		}
+		return nil, ErrTokenExpired
+	}
+
+	err = oa.tokenStore.TouchToken(ctx, token, SessionTouchInterval)
+	if err != nil {
+		oa.logger.Printf("Error in Authenticate: Touching token - %v", err)
+	}
+
+	return user, nil
+}
+
+func (oa *OpaqueTokenAuthenticator) NewAccessToken(user *db.User, family string) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(user.ID, tokens.AuthTTL, tokens.ScopeAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+	return token, nil
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// clockSkew is how far apart our instances' clocks may drift when checking
+// token lifetimes.
+const clockSkew = 30 * time.Second
+
+var errMalformedJWT = errors.New("auth: malformed jwt")
+
+var jwtEncoding = base64.RawURLEncoding
+
+// Key signs and verifies JWTs for a single key ID.
+type Key struct {
+	ID         string
+	Algorithm  string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// NewHS256Key returns an HMAC-SHA256 key. Secrets shorter than 32 bytes are
+// rejected.
+func NewHS256Key(id string, secret []byte) (*Key, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("auth: HS256 key %q must be at least 32 bytes", id)
+	}
+	return &Key{ID: id, Algorithm: AlgorithmHS256, secret: secret}, nil
+}
+
+// NewEdDSAKey returns an Ed25519 key derived from a 32 byte seed.
+func NewEdDSAKey(id string, seed []byte) (*Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("auth: EdDSA key %q must be a %d byte seed", id, ed25519.SeedSize)
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	return &Key{
+		ID:         id,
+		Algorithm:  AlgorithmEdDSA,
+		privateKey: privateKey,
+		publicKey:  privateKey.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// ParseKeys reads a comma separated list of id:base64-secret pairs, e.g.
+// "2025-06:bXktc2VjcmV0...,2025-01:b2xkLXNlY3JldC4uLg==". The first key signs
+// new tokens; the rest are still accepted so keys can be rotated without
+// logging everybody out.
+func ParseKeys(algorithm, spec string) ([]*Key, error) {
+	var keys []*Key
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("auth: jwt key %q must be in id:base64 form", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("auth: jwt key %q: %w", id, err)
+		}
+
+		var key *Key
+		switch algorithm {
+		case AlgorithmHS256:
+			key, err = NewHS256Key(id, material)
+		case AlgorithmEdDSA:
+			key, err = NewEdDSAKey(id, material)
+		default:
+			err = fmt.Errorf("auth: unsupported jwt algorithm %q", algorithm)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("auth: at least one jwt key is required")
+	}
+
+	return keys, nil
+}
+
+func (k *Key) sign(signingInput []byte) []byte {
+	if k.Algorithm == AlgorithmEdDSA {
+		return ed25519.Sign(k.privateKey, signingInput)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil)
+}
+
+func (k *Key) verify(signingInput, signature []byte) bool {
+	if k.Algorithm == AlgorithmEdDSA {
+		return ed25519.Verify(k.publicKey, signingInput, signature)
+	}
+	return hmac.Equal(k.sign(signingInput), signature)
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Claims carried by access tokens. The profile claims let most requests be
+// served without looking the user up.
+type Claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+	SessionID string `json:"sid,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Activated bool   `json:"activated"`
+	Role      string `json:"role,omitempty"`
+}
+
+type JWTConfig struct {
+	Issuer string
+	TTL    time.Duration
+	// Keys are tried by key ID when verifying; the first one signs.
+	Keys []*Key
+}
+
+// JWTAuthenticator issues short-lived signed access tokens and verifies them
+// without a database round trip. Because verification is stateless, revoking
+// a session only stops its refresh token; access tokens already issued stay
+// valid until they expire, so keep the TTL short.
+type JWTAuthenticator struct {
+	userStore db.UserStore
+	issuer    string
+	ttl       time.Duration
+	signer    *Key
+	keys      map[string]*Key
+	now       func() time.Time
+}
+
+func NewJWTAuthenticator(userStore db.UserStore, cfg JWTConfig) (*JWTAuthenticator, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("auth: at least one jwt key is required")
+	}
+
+	keys := make(map[string]*Key, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		if _, exists := keys[key.ID]; exists {
+			return nil, fmt.Errorf("auth: duplicate jwt key id %q", key.ID)
+		}
+		keys[key.ID] = key
+	}
+
+	return &JWTAuthenticator{
+		userStore: userStore,
+		issuer:    cfg.Issuer,
+		ttl:       cfg.TTL,
+		signer:    cfg.Keys[0],
+		keys:      keys,
+		now:       time.Now,
+	}, nil
+}
+
+func (ja *JWTAuthenticator) NewAccessToken(user *db.User, family string) (*tokens.Token, error) {
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ja.now()
+	expiry := now.Add(ja.ttl)
+
+	claims := Claims{
+		Issuer:    ja.issuer,
+		Subject:   strconv.Itoa(user.ID),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiry.Unix(),
+		ID:        jwtEncoding.EncodeToString(jti),
+		SessionID: family,
+		Username:  user.Username,
+		Email:     user.Email,
+		Activated: user.Activated,
+		Role:      user.Role,
+	}
+
+	signed, err := ja.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokens.Token{
+		Plaintext: signed,
+		Hash:      tokens.HashToken(signed),
+		UserID:    user.ID,
+		Expiry:    time.Unix(claims.ExpiresAt, 0),
+		Scope:     tokens.ScopeAuth,
+		Family:    family,
+	}, nil
+}
+
+// Authenticate verifies the token's signature and lifetime and builds the user
+// from its claims. Only tokens without profile claims need a database lookup.
+func (ja *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*db.User, error) {
+	claims, err := ja.Verify(token)
+	if errors.Is(err, ErrTokenExpired) {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, nil
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, nil
+	}
+
+	if claims.Username == "" {
+		user, err := ja.userStore.GetUserByID(ctx, int64(userID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return user, err
+	}
+
+	return &db.User{
+		ID:        userID,
+		Username:  claims.Username,
+		Email:     claims.Email,
+		Activated: claims.Activated,
+		Role:      claims.Role,
+	}, nil
+}
+
+// Recheck verifies the token and then finds it among the stored sessions, so
+// a token whose session was revoked or whose user was deleted or locked stops
+// working before it expires. The user comes from the database, not the
+// claims, so a changed role applies at once.
+func (ja *JWTAuthenticator) Recheck(ctx context.Context, token string) (*db.User, error) {
+	_, err := ja.Verify(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	user, err := ja.userStore.GetUserByToken(ctx, token, tokens.ScopeAuth)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	if user.Locked() {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+func (ja *JWTAuthenticator) sign(claims Claims) (string, error) {
+	header, err := json.Marshal(jwtHeader{Algorithm: ja.signer.Algorithm, Type: "JWT", KeyID: ja.signer.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(payload)
+	signature := ja.signer.sign([]byte(signingInput))
+
+	return signingInput + "." + jwtEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks a token's signature, issuer and lifetime and returns its claims.
+func (ja *JWTAuthenticator) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedJWT
+	}
+
+	headerJSON, err := jwtEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedJWT
+	}
+
+	var header jwtHeader
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil {
+		return nil, errMalformedJWT
+	}
+
+	key, ok := ja.keys[header.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("auth: unknown jwt key id %q", header.KeyID)
+	}
+
+	// The algorithm is fixed by the key, never by the token
+	if header.Algorithm != key.Algorithm {
+		return nil, fmt.Errorf("auth: jwt algorithm %q does not match key %q", header.Algorithm, key.ID)
+	}
+
+	signature, err := jwtEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedJWT
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, errors.New("auth: invalid jwt signature")
+	}
+
+	payload, err := jwtEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedJWT
+	}
+
+	var claims Claims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, errMalformedJWT
+	}
+
+	if claims.Issuer != ja.issuer {
+		return nil, fmt.Errorf("auth: unexpected jwt issuer %q", claims.Issuer)
+	}
+
+	now := ja.now()
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return nil, ErrTokenExpired
+	}
+
+	if now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, errors.New("auth: jwt issued in the future")
+	}
+
+	return &claims, nil
+}
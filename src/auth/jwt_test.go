@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+func testKey(t *testing.T, algorithm, id string, fill byte) *Key {
+	t.Helper()
+
+	material := bytes.Repeat([]byte{fill}, 32)
+	keys, err := ParseKeys(algorithm, id+":"+base64.StdEncoding.EncodeToString(material))
+	require.NoError(t, err)
+	return keys[0]
+}
+
+func newTestAuthenticator(t *testing.T, keys ...*Key) *JWTAuthenticator {
+	t.Helper()
+
+	authenticator, err := NewJWTAuthenticator(nil, JWTConfig{
+		Issuer: "test",
+		TTL:    15 * time.Minute,
+		Keys:   keys,
+	})
+	require.NoError(t, err)
+	return authenticator
+}
+
+var testUser = &db.User{ID: 42, Username: "example", Email: "example@example.com", Activated: true, Role: db.RoleAdmin}
+
+func TestJWTRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmHS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			authenticator := newTestAuthenticator(t, testKey(t, algorithm, "k1", 1))
+
+			token, err := authenticator.NewAccessToken(testUser, "family")
+			require.NoError(t, err)
+			assert.Equal(t, tokens.ScopeAuth, token.Scope)
+			assert.Equal(t, "family", token.Family)
+			assert.Equal(t, tokens.HashToken(token.Plaintext), token.Hash)
+
+			user, err := authenticator.Authenticate(context.Background(), token.Plaintext)
+			require.NoError(t, err)
+			require.NotNil(t, user)
+			assert.Equal(t, testUser.ID, user.ID)
+			assert.Equal(t, testUser.Username, user.Username)
+			assert.Equal(t, testUser.Email, user.Email)
+			assert.True(t, user.Activated)
+			assert.Equal(t, db.RoleAdmin, user.Role)
+		})
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	oldKey := testKey(t, AlgorithmHS256, "old", 1)
+	newKey := testKey(t, AlgorithmHS256, "new", 2)
+
+	before := newTestAuthenticator(t, oldKey)
+	token, err := before.NewAccessToken(testUser, "")
+	require.NoError(t, err)
+
+	// The new key signs, the old one is still accepted
+	after := newTestAuthenticator(t, newKey, oldKey)
+	_, err = after.Verify(token.Plaintext)
+	assert.NoError(t, err)
+
+	// Once the old key is retired its tokens are rejected
+	retired := newTestAuthenticator(t, newKey)
+	_, err = retired.Verify(token.Plaintext)
+	assert.Error(t, err)
+}
+
+func TestJWTRejected(t *testing.T) {
+	key := testKey(t, AlgorithmHS256, "k1", 1)
+	authenticator := newTestAuthenticator(t, key)
+
+	token, err := authenticator.NewAccessToken(testUser, "")
+	require.NoError(t, err)
+	parts := strings.Split(token.Plaintext, ".")
+
+	t.Run("tampered payload", func(t *testing.T) {
+		payload := jwtEncoding.EncodeToString([]byte(`{"iss":"test","sub":"1","username":"admin","exp":9999999999}`))
+		_, err := authenticator.Verify(parts[0] + "." + payload + "." + parts[2])
+		assert.Error(t, err)
+	})
+
+	t.Run("algorithm mismatch", func(t *testing.T) {
+		header := jwtEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"k1"}`))
+		_, err := authenticator.Verify(header + "." + parts[1] + ".")
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		other, err := NewJWTAuthenticator(nil, JWTConfig{Issuer: "other", TTL: time.Minute, Keys: []*Key{key}})
+		require.NoError(t, err)
+		_, err = other.Verify(token.Plaintext)
+		assert.Error(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		authenticator.now = func() time.Time { return time.Now().Add(time.Hour) }
+		defer func() { authenticator.now = time.Now }()
+
+		user, err := authenticator.Authenticate(context.Background(), token.Plaintext)
+		assert.ErrorIs(t, err, ErrTokenExpired)
+		assert.Nil(t, user)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := authenticator.Verify("not-a-jwt")
+		assert.Error(t, err)
+	})
+
+	t.Run("recheck", func(t *testing.T) {
+		// Tokens that fail verification are refused before the store is asked
+		payload := jwtEncoding.EncodeToString([]byte(`{"iss":"test","sub":"1","username":"admin","exp":9999999999}`))
+		user, err := authenticator.Recheck(context.Background(), parts[0]+"."+payload+"."+parts[2])
+		assert.NoError(t, err)
+		assert.Nil(t, user)
+	})
+}
+
+func TestParseKeys(t *testing.T) {
+	_, err := ParseKeys(AlgorithmHS256, "")
+	assert.Error(t, err)
+
+	_, err = ParseKeys(AlgorithmHS256, "k1:"+base64.StdEncoding.EncodeToString([]byte("too-short")))
+	assert.Error(t, err)
+
+	_, err = ParseKeys("RS256", "k1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Admin actions recorded in the audit log.
+const (
+	AdminActionListUsers       = "users.list"
+	AdminActionViewUser        = "users.view"
+	AdminActionLockUser        = "users.lock"
+	AdminActionUnlockUser      = "users.unlock"
+	AdminActionSetRole         = "users.set_role"
+	AdminActionRevokeTokens    = "users.revoke_tokens"
+	AdminActionViewAuditLog    = "audit_log.view"
+	AdminActionViewMaintenance = "maintenance.view"
+	AdminActionViewSecurityLog = "security_events.view"
+)
+
+// AdminAction is an audit log entry for something an admin did.
+type AdminAction struct {
+	ID           int64          `json:"id"`
+	ActorID      int            `json:"actor_id"`
+	Action       string         `json:"action"`
+	TargetUserID *int           `json:"target_user_id"`
+	Details      map[string]any `json:"details"`
+	IP           string         `json:"ip"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+type PostgresAdminStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAdminStore(db *sql.DB) *PostgresAdminStore {
+	return &PostgresAdminStore{db: db}
+}
+
+// AdminStore holds the operations behind the admin API. Each method that acts
+// on a user records the action in the same transaction, so nothing happens
+// without an audit entry.
+type AdminStore interface {
+	ListUsers(ctx context.Context, search string, limit, offset int) ([]*User, int, error)
+	SetUserLocked(ctx context.Context, userID int64, locked bool, action *AdminAction) error
+	SetUserRole(ctx context.Context, userID int64, role string, action *AdminAction) error
+	RevokeUserTokens(ctx context.Context, userID int64, action *AdminAction) (int64, error)
+	RecordAction(ctx context.Context, action *AdminAction) error
+	ListActions(ctx context.Context, targetUserID int64, limit, offset int) ([]*AdminAction, error)
+}
+
+// ListUsers returns a page of users whose username or email contains search,
+// along with the total number of matches. An empty search matches everyone.
+func (pg *PostgresAdminStore) ListUsers(ctx context.Context, search string, limit, offset int) ([]*User, int, error) {
+	query := `
+	SELECT id, username, email, activated, role, locked_at, created_at, updated_at, COUNT(*) OVER()
+	FROM users
+	WHERE deleted_at IS NULL AND ($1 = '' OR username ILIKE $2 OR email ILIKE $2)
+	ORDER BY id
+	LIMIT $3 OFFSET $4
+	`
+
+	pattern := "%" + likeEscaper.Replace(search) + "%"
+
+	rows, err := pg.db.QueryContext(ctx, query, search, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+	total := 0
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.Activated,
+			&user.Role,
+			&user.LockedAt,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+
+	return users, total, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SetUserLocked locks or unlocks an account. Locking also signs the user out
+// everywhere and revokes their API keys.
+func (pg *PostgresAdminStore) SetUserLocked(ctx context.Context, userID int64, locked bool, action *AdminAction) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	UPDATE users
+	SET locked_at = CASE WHEN $2 THEN COALESCE(locked_at, CURRENT_TIMESTAMP) END
+	WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	err = execUserUpdate(ctx, tx, userID, query, userID, locked)
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		_, err = deleteUserCredentials(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertAdminAction(ctx, tx, action)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetUserRole changes a user's role and signs them out everywhere, so no
+// session, including a JWT carrying the old role in its claims, outlives the
+// change. API keys are looked up on every request and are kept.
+func (pg *PostgresAdminStore) SetUserRole(ctx context.Context, userID int64, role string, action *AdminAction) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	UPDATE users
+	SET role = $2, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	err = execUserUpdate(ctx, tx, userID, query, userID, role)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAdminAction(ctx, tx, action)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeUserTokens deletes every token and API key the user holds and
+// returns how many there were.
+func (pg *PostgresAdminStore) RevokeUserTokens(ctx context.Context, userID int64, action *AdminAction) (int64, error) {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	revoked, err := deleteUserCredentials(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertAdminAction(ctx, tx, action)
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+// RecordAction logs an action that changes nothing, such as viewing a user.
+func (pg *PostgresAdminStore) RecordAction(ctx context.Context, action *AdminAction) error {
+	return insertAdminAction(ctx, pg.db, action)
+}
+
+// ListActions returns audit entries, newest first. A targetUserID of 0 lists
+// entries for every user.
+func (pg *PostgresAdminStore) ListActions(ctx context.Context, targetUserID int64, limit, offset int) ([]*AdminAction, error) {
+	query := `
+	SELECT id, actor_id, action, target_user_id, details, ip, created_at
+	FROM admin_audit_log
+	WHERE $1::BIGINT = 0 OR target_user_id = $1
+	ORDER BY created_at DESC, id DESC
+	LIMIT $2 OFFSET $3
+	`
+
+	rows, err := pg.db.QueryContext(ctx, query, targetUserID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	actions := []*AdminAction{}
+	for rows.Next() {
+		action := &AdminAction{}
+		var details []byte
+		err := rows.Scan(
+			&action.ID,
+			&action.ActorID,
+			&action.Action,
+			&action.TargetUserID,
+			&details,
+			&action.IP,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(details, &action.Details)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, rows.Err()
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertAdminAction(ctx context.Context, db rowQuerier, action *AdminAction) error {
+	details := action.Details
+	if details == nil {
+		details = map[string]any{}
+	}
+
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO admin_audit_log (actor_id, action, target_user_id, details, ip)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at
+	`
+
+	return db.QueryRowContext(ctx, query,
+		action.ActorID,
+		action.Action,
+		action.TargetUserID,
+		string(encoded),
+		action.IP,
+	).Scan(&action.ID, &action.CreatedAt)
+}
+
+// execUserUpdate runs an update against one user and reports sql.ErrNoRows if
+// there is no such user.
+func execUserUpdate(ctx context.Context, tx *sql.Tx, userID int64, query string, args ...any) error {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no user with id %d: %w", userID, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func deleteUserCredentials(ctx context.Context, tx *sql.Tx, userID int64) (int64, error) {
+	var deleted int64
+	for _, query := range []string{
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+	} {
+		result, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return 0, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += rowsAffected
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+func TestAdminStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresAdminStore(db)
+	userStore := NewPostgresUserStore(db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	admin, err := userStore.RegisterUser(ctx, validUser("admin", "admin@example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, RoleUser, admin.Role, "new users get the user role")
+
+	target, err := userStore.RegisterUser(ctx, validUser("mover_one", "mover@example.com"))
+	require.NoError(t, err)
+
+	action := func(name string) *AdminAction {
+		return &AdminAction{ActorID: admin.ID, Action: name, TargetUserID: &target.ID, IP: "192.0.2.1"}
+	}
+
+	t.Run("Search escapes wildcards", func(t *testing.T) {
+		users, total, err := store.ListUsers(ctx, "ad_in", 10, 0)
+		require.NoError(t, err)
+		assert.Empty(t, users)
+		assert.Zero(t, total)
+
+		users, total, err = store.ListUsers(ctx, "MOVER_", 10, 0)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, 1, total)
+		assert.Equal(t, target.ID, users[0].ID)
+
+		users, total, err = store.ListUsers(ctx, "", 1, 1)
+		require.NoError(t, err)
+		assert.Len(t, users, 1)
+		assert.Equal(t, 2, total)
+	})
+
+	t.Run("Locking signs the user out", func(t *testing.T) {
+		session, err := tokenStore.GenerateToken(ctx, int64(target.ID), tokens.AuthTTL, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		require.NoError(t, store.SetUserLocked(ctx, int64(target.ID), true, action(AdminActionLockUser)))
+
+		locked, err := userStore.GetUserByID(ctx, int64(target.ID))
+		require.NoError(t, err)
+		assert.True(t, locked.Locked())
+
+		signedIn, err := userStore.GetUserByToken(ctx, session.Plaintext, tokens.ScopeAuth)
+		require.NoError(t, err)
+		assert.Nil(t, signedIn)
+
+		require.NoError(t, store.SetUserLocked(ctx, int64(target.ID), false, action(AdminActionUnlockUser)))
+
+		unlocked, err := userStore.GetUserByID(ctx, int64(target.ID))
+		require.NoError(t, err)
+		assert.False(t, unlocked.Locked())
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		require.NoError(t, store.SetUserRole(ctx, int64(target.ID), RoleAdmin, action(AdminActionSetRole)))
+
+		promoted, err := userStore.GetUserByID(ctx, int64(target.ID))
+		require.NoError(t, err)
+		assert.True(t, promoted.HasRole(RoleUser))
+		assert.True(t, promoted.HasRole(RoleAdmin))
+
+		// Sessions issued while an admin end with the demotion
+		session, err := tokenStore.GenerateToken(ctx, int64(target.ID), tokens.AuthTTL, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		require.NoError(t, store.SetUserRole(ctx, int64(target.ID), RoleUser, action(AdminActionSetRole)))
+
+		signedIn, err := userStore.GetUserByToken(ctx, session.Plaintext, tokens.ScopeAuth)
+		require.NoError(t, err)
+		assert.Nil(t, signedIn)
+
+		assert.Error(t, store.SetUserRole(ctx, int64(target.ID), "owner", action(AdminActionSetRole)))
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		err := store.SetUserLocked(ctx, 999999, true, action(AdminActionLockUser))
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("Revoking tokens", func(t *testing.T) {
+		for range 2 {
+			_, err := tokenStore.GenerateToken(ctx, int64(target.ID), tokens.AuthTTL, tokens.ScopeAuth)
+			require.NoError(t, err)
+		}
+
+		revoked, err := store.RevokeUserTokens(ctx, int64(target.ID), action(AdminActionRevokeTokens))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), revoked)
+	})
+
+	require.NoError(t, store.RecordAction(ctx, &AdminAction{
+		ActorID: admin.ID,
+		Action:  AdminActionListUsers,
+		Details: map[string]any{"search": "mover"},
+	}))
+
+	entries, err := store.ListActions(ctx, int64(target.ID), 50, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 4, "failed actions leave no entry")
+	assert.Equal(t, AdminActionRevokeTokens, entries[0].Action)
+	assert.Equal(t, "192.0.2.1", entries[0].IP)
+
+	entries, err = store.ListActions(ctx, 0, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, AdminActionListUsers, entries[0].Action)
+	assert.Nil(t, entries[0].TargetUserID)
+	assert.Equal(t, "mover", entries[0].Details["search"])
+}
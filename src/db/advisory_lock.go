@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// AdvisoryLocker runs work that only one instance of the server should be
+// doing at a time.
+type AdvisoryLocker interface {
+	// TryLock runs fn while holding the lock called name and reports true, or
+	// reports false without running fn if another session holds it.
+	TryLock(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error)
+}
+
+type PostgresAdvisoryLocker struct {
+	db *sql.DB
+}
+
+func NewPostgresAdvisoryLocker(db *sql.DB) *PostgresAdvisoryLocker {
+	return &PostgresAdvisoryLocker{db: db}
+}
+
+// TryLock takes a transaction-level advisory lock, so Postgres releases it
+// when the transaction ends even if fn panics or the connection drops. fn runs
+// on the pool, not inside the transaction.
+func (pg *PostgresAdvisoryLocker) TryLock(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error) {
+	transaction, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	defer transaction.Rollback()
+
+	var locked bool
+	err = transaction.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtextextended($1, 0))`, "moving-checklist:"+name).Scan(&locked)
+	if err != nil {
+		return false, err
+	}
+
+	if !locked {
+		return false, nil
+	}
+
+	return true, fn(ctx)
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdvisoryLocker(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	locker := NewPostgresAdvisoryLocker(db)
+	ctx := context.Background()
+
+	var ran, nestedRan, otherRan bool
+	locked, err := locker.TryLock(ctx, "sweep", func(ctx context.Context) error {
+		ran = true
+
+		// A second holder, as another instance would be, is turned away
+		nested, err := locker.TryLock(ctx, "sweep", func(ctx context.Context) error {
+			nestedRan = true
+			return nil
+		})
+		require.NoError(t, err)
+		assert.False(t, nested)
+
+		other, err := locker.TryLock(ctx, "purge", func(ctx context.Context) error {
+			otherRan = true
+			return nil
+		})
+		require.NoError(t, err)
+		assert.True(t, other, "other lock names are independent")
+
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, locked)
+	assert.True(t, ran)
+	assert.False(t, nestedRan)
+	assert.True(t, otherRan)
+
+	// Released once the first holder is done
+	locked, err = locker.TryLock(ctx, "sweep", func(ctx context.Context) error { return nil })
+	require.NoError(t, err)
+	assert.True(t, locked)
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKey struct {
+	ID          int64      `json:"id"`
+	UserID      int        `json:"-"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Hash        []byte     `json:"-"`
+	Permissions []string   `json:"permissions"`
+	Expiry      *time.Time `json:"expiry"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+// HasPermission reports whether the key was granted permission.
+func (k *APIKey) HasPermission(permission string) bool {
+	for _, granted := range k.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type PostgresAPIKeyStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAPIKeyStore(db *sql.DB) *PostgresAPIKeyStore {
+	return &PostgresAPIKeyStore{db: db}
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	ListAPIKeysForUser(ctx context.Context, userID int) ([]*APIKey, error)
+	DeleteAPIKey(ctx context.Context, id int64, userID int) error
+	GetUserByAPIKey(ctx context.Context, plaintext string) (*User, *APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64, interval time.Duration) error
+}
+
+func (pg *PostgresAPIKeyStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	query := `
+	INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+
+	return pg.db.QueryRowContext(ctx, query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		key.Permissions,
+		key.Expiry,
+	).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (pg *PostgresAPIKeyStore) ListAPIKeysForUser(ctx context.Context, userID int) ([]*APIKey, error) {
+	query := `
+	SELECT id, user_id, name, prefix, hash, permissions, expiry, created_at, last_used_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC
+	`
+
+	rows, err := pg.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	// pgtype.Map caches scan plans and is not safe to share between requests
+	typeMap := pgtype.NewMap()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key := &APIKey{}
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			&key.Hash,
+			typeMap.SQLScanner(&key.Permissions),
+			&key.Expiry,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (pg *PostgresAPIKeyStore) DeleteAPIKey(ctx context.Context, id int64, userID int) error {
+	query := `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+
+	result, err := pg.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// GetUserByAPIKey resolves an unexpired API key to its owner. It returns nils
+// if the key is unknown, expired or revoked.
+func (pg *PostgresAPIKeyStore) GetUserByAPIKey(ctx context.Context, plaintext string) (*User, *APIKey, error) {
+	user := &User{}
+	key := &APIKey{}
+	typeMap := pgtype.NewMap()
+
+	query := `
+	SELECT u.id, u.username, u.email, u.password_hash, u.activated, u.role, u.locked_at, u.created_at, u.updated_at,
+		k.id, k.user_id, k.name, k.prefix, k.hash, k.permissions, k.expiry, k.created_at, k.last_used_at
+	FROM api_keys k
+	INNER JOIN users u ON u.id = k.user_id
+	WHERE k.hash = $1 AND (k.expiry IS NULL OR k.expiry > CURRENT_TIMESTAMP)
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, tokens.HashToken(plaintext)).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.Activated,
+		&user.Role,
+		&user.LockedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		typeMap.SQLScanner(&key.Permissions),
+		&key.Expiry,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, key, nil
+}
+
+// TouchAPIKey records that a key was just used, at most once per interval.
+func (pg *PostgresAPIKeyStore) TouchAPIKey(ctx context.Context, id int64, interval time.Duration) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = $1
+	AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+	`
+
+	_, err := pg.db.ExecContext(ctx, query, id, interval.Seconds())
+	return err
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	store := NewPostgresAPIKeyStore(db)
+	ctx := context.Background()
+
+	newKey := func(name string, expiry *time.Time) (string, *APIKey) {
+		plaintext, prefix, hash, err := tokens.GenerateAPIKey()
+		require.NoError(t, err)
+
+		key := &APIKey{
+			UserID:      user.ID,
+			Name:        name,
+			Prefix:      prefix,
+			Hash:        hash,
+			Permissions: []string{tokens.PermissionTasksRead},
+			Expiry:      expiry,
+		}
+		require.NoError(t, store.CreateAPIKey(ctx, key))
+		return plaintext, key
+	}
+
+	plaintext, key := newKey("backup script", nil)
+	expired := time.Now().Add(-time.Hour)
+	expiredPlaintext, _ := newKey("old script", &expired)
+
+	tests := []struct {
+		name      string
+		plaintext string
+		wantFound bool
+	}{
+		{name: "Valid key resolves to its user", plaintext: plaintext, wantFound: true},
+		{name: "Expired key does not resolve", plaintext: expiredPlaintext, wantFound: false},
+		{name: "Unknown key does not resolve", plaintext: tokens.APIKeyPrefix + "UNKNOWN", wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, foundKey, err := store.GetUserByAPIKey(ctx, tt.plaintext)
+			require.NoError(t, err)
+
+			if tt.wantFound {
+				require.NotNil(t, found)
+				assert.Equal(t, user.ID, found.ID)
+				assert.True(t, foundKey.HasPermission(tokens.PermissionTasksRead))
+				assert.False(t, foundKey.HasPermission(tokens.PermissionTasksWrite))
+			} else {
+				assert.Nil(t, found)
+				assert.Nil(t, foundKey)
+			}
+		})
+	}
+
+	keys, err := store.ListAPIKeysForUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	err = store.DeleteAPIKey(ctx, key.ID, user.ID)
+	require.NoError(t, err)
+
+	found, _, err := store.GetUserByAPIKey(ctx, plaintext)
+	require.NoError(t, err)
+	assert.Nil(t, found, "deleted key must not authenticate")
+
+	err = store.DeleteAPIKey(ctx, key.ID, user.ID)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
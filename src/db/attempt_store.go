@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/lockout"
+)
+
+// PostgresAttemptStore shares failed authentication counters between
+// instances.
+type PostgresAttemptStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAttemptStore(db *sql.DB) *PostgresAttemptStore {
+	return &PostgresAttemptStore{db: db}
+}
+
+var _ lockout.Store = (*PostgresAttemptStore)(nil)
+
+func (pg *PostgresAttemptStore) Get(ctx context.Context, key string) (lockout.Attempts, error) {
+	var attempts lockout.Attempts
+
+	query := `
+	SELECT failures, last_failure_at
+	FROM auth_failures
+	WHERE key = $1 AND expires_at > CURRENT_TIMESTAMP
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, key).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err == sql.ErrNoRows {
+		return lockout.Attempts{}, nil
+	}
+	if err != nil {
+		return lockout.Attempts{}, err
+	}
+
+	return attempts, nil
+}
+
+// Increment counts a failure in a single statement so concurrent failures
+// from several instances are all counted.
+func (pg *PostgresAttemptStore) Increment(ctx context.Context, key string, now time.Time, retention time.Duration) (lockout.Attempts, error) {
+	var attempts lockout.Attempts
+
+	query := `
+	INSERT INTO auth_failures (key, failures, last_failure_at, expires_at)
+	VALUES ($1, 1, $2, $3)
+	ON CONFLICT (key) DO UPDATE
+	SET failures = CASE WHEN auth_failures.expires_at < $2 THEN 1 ELSE auth_failures.failures + 1 END,
+		last_failure_at = $2,
+		expires_at = $3
+	RETURNING failures, last_failure_at
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, key, now, now.Add(retention)).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil {
+		return lockout.Attempts{}, err
+	}
+
+	return attempts, nil
+}
+
+func (pg *PostgresAttemptStore) Decrement(ctx context.Context, key string) error {
+	_, err := pg.db.ExecContext(ctx, `UPDATE auth_failures SET failures = failures - 1 WHERE key = $1 AND failures > 0`, key)
+	return err
+}
+
+func (pg *PostgresAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := pg.db.ExecContext(ctx, `DELETE FROM auth_failures WHERE key = $1`, key)
+	return err
+}
+
+// DeleteExpired removes up to limit counters that no longer affect anyone,
+// and reports how many it removed.
+func (pg *PostgresAttemptStore) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+	query := `
+	DELETE FROM auth_failures
+	WHERE key IN (
+		SELECT key FROM auth_failures
+		WHERE expires_at <= CURRENT_TIMESTAMP
+		LIMIT $1
+	)
+	`
+
+	result, err := pg.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
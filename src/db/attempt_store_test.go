@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttemptStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresAttemptStore(db)
+	ctx := context.Background()
+
+	_, err := db.Exec(`TRUNCATE auth_failures`)
+	require.NoError(t, err)
+
+	attempts, err := store.Get(ctx, "account:example@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, attempts.Failures)
+
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		attempts, err = store.Increment(ctx, "account:example@example.com", now, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, i, attempts.Failures)
+	}
+
+	attempts, err = store.Get(ctx, "account:example@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts.Failures)
+	assert.WithinDuration(t, now, attempts.LastFailure, time.Millisecond)
+
+	require.NoError(t, store.Decrement(ctx, "account:example@example.com"))
+	attempts, err = store.Get(ctx, "account:example@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts.Failures)
+
+	// A failure after the counter expired starts a new count
+	attempts, err = store.Increment(ctx, "account:example@example.com", now.Add(2*time.Hour), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempts.Failures)
+
+	require.NoError(t, store.Reset(ctx, "account:example@example.com"))
+	attempts, err = store.Get(ctx, "account:example@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, attempts.Failures)
+
+	for _, key := range []string{"ip:203.0.113.1", "ip:203.0.113.2", "ip:203.0.113.3"} {
+		_, err = store.Increment(ctx, key, now.Add(-2*time.Hour), time.Hour)
+		require.NoError(t, err)
+	}
+
+	deleted, err := store.DeleteExpired(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted, "one batch at a time")
+
+	deleted, err = store.DeleteExpired(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
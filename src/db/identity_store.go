@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+// Identity links a user to an account at an external OpenID Connect provider.
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"-"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginState is what we remember about a sign-in while the user is away at
+// the provider.
+type LoginState struct {
+	Nonce        string
+	CodeVerifier string
+	DeviceName   string
+	// Cookie finishes the sign-in with a browser session in cookies.
+	Cookie bool
+	Expiry time.Time
+}
+
+type PostgresIdentityStore struct {
+	db *sql.DB
+}
+
+func NewPostgresIdentityStore(db *sql.DB) *PostgresIdentityStore {
+	return &PostgresIdentityStore{db: db}
+}
+
+type IdentityStore interface {
+	CreateLoginState(ctx context.Context, state string, loginState *LoginState) error
+	ConsumeLoginState(ctx context.Context, state string) (*LoginState, error)
+	DeleteExpiredLoginStates(ctx context.Context, limit int) (int64, error)
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	CreateUserWithIdentity(ctx context.Context, user *User, identity *Identity) error
+}
+
+func (pg *PostgresIdentityStore) CreateLoginState(ctx context.Context, state string, loginState *LoginState) error {
+	query := `
+	INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, device_name, cookie, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := pg.db.ExecContext(ctx, query,
+		tokens.HashToken(state),
+		loginState.Nonce,
+		loginState.CodeVerifier,
+		loginState.DeviceName,
+		loginState.Cookie,
+		loginState.Expiry,
+	)
+	return err
+}
+
+// ConsumeLoginState deletes and returns the pending sign-in for state, so each
+// state can complete at most one callback. It returns nil if the state is
+// unknown or expired.
+func (pg *PostgresIdentityStore) ConsumeLoginState(ctx context.Context, state string) (*LoginState, error) {
+	loginState := &LoginState{}
+
+	query := `
+	DELETE FROM oidc_login_states
+	WHERE state_hash = $1
+	RETURNING nonce, code_verifier, device_name, cookie, expiry
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, tokens.HashToken(state)).Scan(
+		&loginState.Nonce,
+		&loginState.CodeVerifier,
+		&loginState.DeviceName,
+		&loginState.Cookie,
+		&loginState.Expiry,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(loginState.Expiry) {
+		return nil, nil
+	}
+
+	return loginState, nil
+}
+
+// DeleteExpiredLoginStates removes up to limit sign-ins that were never
+// completed and have expired, and reports how many it removed.
+func (pg *PostgresIdentityStore) DeleteExpiredLoginStates(ctx context.Context, limit int) (int64, error) {
+	query := `
+	DELETE FROM oidc_login_states
+	WHERE state_hash IN (
+		SELECT state_hash FROM oidc_login_states
+		WHERE expiry <= CURRENT_TIMESTAMP
+		LIMIT $1
+	)
+	`
+
+	result, err := pg.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetUserByIdentity returns the user linked to the external account, or nil if
+// the account has not signed in before.
+func (pg *PostgresIdentityStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	user := &User{}
+
+	query := `
+	SELECT u.id, u.username, u.email, u.password_hash, u.activated, u.role, u.locked_at, u.created_at, u.updated_at
+	FROM users u
+	INNER JOIN user_identities i ON i.user_id = u.id
+	WHERE i.issuer = $1 AND i.subject = $2 AND u.deleted_at IS NULL
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.Activated,
+		&user.Role,
+		&user.LockedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// CreateUserWithIdentity registers a new user and links the external account
+// to it in one transaction.
+func (pg *PostgresIdentityStore) CreateUserWithIdentity(ctx context.Context, user *User, identity *Identity) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO users (username, email, password_hash, activated)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, role, created_at, updated_at
+	`
+
+	err = tx.QueryRowContext(ctx, query, user.Username, user.Email, user.PasswordHash, user.Activated).Scan(&user.ID, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return userConflict(err)
+	}
+
+	query = `
+	INSERT INTO user_identities (user_id, issuer, subject, email)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+	`
+
+	err = tx.QueryRowContext(ctx, query, user.ID, identity.Issuer, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+
+	return tx.Commit()
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentities(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresIdentityStore(db)
+	ctx := context.Background()
+
+	user, err := store.GetUserByIdentity(ctx, "https://issuer.example.com", "external-1")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	created := validUser("external", "external@example.com")
+	identity := &Identity{Issuer: "https://issuer.example.com", Subject: "external-1", Email: "external@example.com"}
+	require.NoError(t, store.CreateUserWithIdentity(ctx, created, identity))
+	assert.NotZero(t, created.ID)
+	assert.Equal(t, created.ID, identity.UserID)
+
+	user, err = store.GetUserByIdentity(ctx, "https://issuer.example.com", "external-1")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, created.ID, user.ID)
+
+	// The same subject at another issuer is a different account
+	user, err = store.GetUserByIdentity(ctx, "https://other.example.com", "external-1")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	// A failed identity insert must not leave the user behind
+	duplicate := validUser("duplicate", "duplicate@example.com")
+	err = store.CreateUserWithIdentity(ctx, duplicate, &Identity{Issuer: "https://issuer.example.com", Subject: "external-1"})
+	assert.Error(t, err)
+
+	exists, err := NewPostgresUserStore(db).CheckEmailExists(ctx, "duplicate@example.com")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestLoginStates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresIdentityStore(db)
+	ctx := context.Background()
+
+	_, err := db.Exec(`TRUNCATE oidc_login_states`)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateLoginState(ctx, "state-1", &LoginState{
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+		DeviceName:   "laptop",
+		Cookie:       true,
+		Expiry:       time.Now().Add(time.Minute),
+	}))
+	require.NoError(t, store.CreateLoginState(ctx, "state-expired", &LoginState{
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+		Expiry:       time.Now().Add(-time.Minute),
+	}))
+
+	loginState, err := store.ConsumeLoginState(ctx, "state-1")
+	require.NoError(t, err)
+	require.NotNil(t, loginState)
+	assert.Equal(t, "nonce", loginState.Nonce)
+	assert.Equal(t, "verifier", loginState.CodeVerifier)
+	assert.Equal(t, "laptop", loginState.DeviceName)
+	assert.True(t, loginState.Cookie)
+
+	// States are single use
+	loginState, err = store.ConsumeLoginState(ctx, "state-1")
+	require.NoError(t, err)
+	assert.Nil(t, loginState)
+
+	require.NoError(t, store.CreateLoginState(ctx, "state-pending", &LoginState{
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+		Expiry:       time.Now().Add(time.Minute),
+	}))
+
+	// Only expired states are swept
+	deleted, err := store.DeleteExpiredLoginStates(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	loginState, err = store.ConsumeLoginState(ctx, "state-pending")
+	require.NoError(t, err)
+	assert.NotNil(t, loginState)
+
+	loginState, err = store.ConsumeLoginState(ctx, "state-expired")
+	require.NoError(t, err)
+	assert.Nil(t, loginState)
+}
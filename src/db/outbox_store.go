@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type OutboxEmail struct {
+	ID        int64      `json:"id"`
+	Recipient string     `json:"recipient"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at"`
+}
+
+type PostgresOutboxStore struct {
+	db *sql.DB
+}
+
+func NewPostgresOutboxStore(db *sql.DB) *PostgresOutboxStore {
+	return &PostgresOutboxStore{db: db}
+}
+
+type OutboxStore interface {
+	InsertEmail(ctx context.Context, email *OutboxEmail) error
+	ListEmailsByRecipient(ctx context.Context, recipient string) ([]*OutboxEmail, error)
+}
+
+func (pg *PostgresOutboxStore) InsertEmail(ctx context.Context, email *OutboxEmail) error {
+	query := `
+	INSERT INTO outbox_emails (recipient, subject, body)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at
+	`
+
+	return pg.db.QueryRowContext(ctx, query, email.Recipient, email.Subject, email.Body).Scan(&email.ID, &email.CreatedAt)
+}
+
+func (pg *PostgresOutboxStore) ListEmailsByRecipient(ctx context.Context, recipient string) ([]*OutboxEmail, error) {
+	query := `
+	SELECT id, recipient, subject, body, created_at, sent_at
+	FROM outbox_emails
+	WHERE recipient = $1
+	ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := pg.db.QueryContext(ctx, query, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var emails []*OutboxEmail
+	for rows.Next() {
+		email := &OutboxEmail{}
+		err := rows.Scan(
+			&email.ID,
+			&email.Recipient,
+			&email.Subject,
+			&email.Body,
+			&email.CreatedAt,
+			&email.SentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, rows.Err()
+}
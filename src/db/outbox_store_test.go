@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertEmail(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresOutboxStore(db)
+	ctx := context.Background()
+
+	_, err := db.Exec(`DELETE FROM outbox_emails WHERE recipient = 'outbox@example.com'`)
+	require.NoError(t, err)
+
+	email := &OutboxEmail{
+		Recipient: "outbox@example.com",
+		Subject:   "Activate your account",
+		Body:      "token: ABC",
+	}
+
+	err = store.InsertEmail(ctx, email)
+	require.NoError(t, err)
+	assert.NotZero(t, email.ID)
+	assert.False(t, email.CreatedAt.IsZero())
+
+	emails, err := store.ListEmailsByRecipient(ctx, "outbox@example.com")
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+	assert.Equal(t, email.Subject, emails[0].Subject)
+	assert.Equal(t, email.Body, emails[0].Body)
+	assert.Nil(t, emails[0].SentAt)
+}
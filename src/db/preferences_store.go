@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Days a user's week can start on.
+const (
+	WeekStartMonday   = "monday"
+	WeekStartSunday   = "sunday"
+	WeekStartSaturday = "saturday"
+)
+
+// Date formats a user can choose to have dates shown in.
+const (
+	DateFormatISO = "YYYY-MM-DD"
+	DateFormatDMY = "DD/MM/YYYY"
+	DateFormatMDY = "MM/DD/YYYY"
+	DateFormatDot = "DD.MM.YYYY"
+)
+
+// Preferences are a user's display and reminder settings. TimeZone is an IANA
+// name such as "America/Denver" and decides which day it is for the user.
+type Preferences struct {
+	TimeZone            string     `json:"time_zone"`
+	Locale              string     `json:"locale"`
+	DateFormat          string     `json:"date_format"`
+	WeekStart           string     `json:"week_start"`
+	ReminderLeadMinutes int        `json:"reminder_lead_minutes"`
+	UpdatedAt           *time.Time `json:"updated_at"`
+}
+
+// DefaultPreferences returns the preferences of a user who has not set any.
+func DefaultPreferences() *Preferences {
+	return &Preferences{
+		TimeZone:            "UTC",
+		Locale:              "en-US",
+		DateFormat:          DateFormatISO,
+		WeekStart:           WeekStartMonday,
+		ReminderLeadMinutes: 24 * 60,
+	}
+}
+
+// Location returns the user's time zone, or UTC if it is no longer known to
+// the time zone database.
+func (p *Preferences) Location() *time.Location {
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+type PostgresPreferencesStore struct {
+	db *sql.DB
+}
+
+func NewPostgresPreferencesStore(db *sql.DB) *PostgresPreferencesStore {
+	return &PostgresPreferencesStore{db: db}
+}
+
+type PreferencesStore interface {
+	// GetPreferences returns the defaults for a user who has not set any.
+	GetPreferences(ctx context.Context, userID int) (*Preferences, error)
+	UpdatePreferences(ctx context.Context, userID int, prefs *Preferences) error
+}
+
+func (pg *PostgresPreferencesStore) GetPreferences(ctx context.Context, userID int) (*Preferences, error) {
+	prefs := &Preferences{}
+
+	query := `
+	SELECT time_zone, locale, date_format, week_start, reminder_lead_minutes, updated_at
+	FROM user_preferences
+	WHERE user_id = $1
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.TimeZone,
+		&prefs.Locale,
+		&prefs.DateFormat,
+		&prefs.WeekStart,
+		&prefs.ReminderLeadMinutes,
+		&prefs.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return DefaultPreferences(), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return prefs, nil
+}
+
+func (pg *PostgresPreferencesStore) UpdatePreferences(ctx context.Context, userID int, prefs *Preferences) error {
+	query := `
+	INSERT INTO user_preferences (user_id, time_zone, locale, date_format, week_start, reminder_lead_minutes)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (user_id) DO UPDATE
+	SET time_zone = EXCLUDED.time_zone,
+		locale = EXCLUDED.locale,
+		date_format = EXCLUDED.date_format,
+		week_start = EXCLUDED.week_start,
+		reminder_lead_minutes = EXCLUDED.reminder_lead_minutes,
+		updated_at = CURRENT_TIMESTAMP
+	RETURNING updated_at
+	`
+
+	return pg.db.QueryRowContext(ctx, query,
+		userID,
+		prefs.TimeZone,
+		prefs.Locale,
+		prefs.DateFormat,
+		prefs.WeekStart,
+		prefs.ReminderLeadMinutes,
+	).Scan(&prefs.UpdatedAt)
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreferences(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresPreferencesStore(db)
+	ctx := context.Background()
+
+	user := createTestUser(t, db)
+
+	prefs, err := store.GetPreferences(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPreferences(), prefs, "users start with the defaults")
+	assert.Equal(t, time.UTC, prefs.Location())
+
+	prefs.TimeZone = "America/Denver"
+	prefs.WeekStart = WeekStartSunday
+	require.NoError(t, store.UpdatePreferences(ctx, user.ID, prefs))
+	require.NotNil(t, prefs.UpdatedAt)
+
+	prefs.Locale = "en-GB"
+	require.NoError(t, store.UpdatePreferences(ctx, user.ID, prefs))
+
+	fetched, err := store.GetPreferences(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "America/Denver", fetched.TimeZone)
+	assert.Equal(t, "en-GB", fetched.Locale)
+	assert.Equal(t, DateFormatISO, fetched.DateFormat)
+	assert.Equal(t, WeekStartSunday, fetched.WeekStart)
+	assert.Equal(t, 24*60, fetched.ReminderLeadMinutes)
+	assert.Equal(t, "America/Denver", fetched.Location().String())
+
+	t.Run("Invalid week start", func(t *testing.T) {
+		prefs.WeekStart = "friday"
+		assert.Error(t, store.UpdatePreferences(ctx, user.ID, prefs))
+	})
+
+	t.Run("Unknown time zone falls back to UTC", func(t *testing.T) {
+		prefs := &Preferences{TimeZone: "Mars/Olympus_Mons"}
+		assert.Equal(t, time.UTC, prefs.Location())
+	})
+
+	t.Run("Deleted with the user", func(t *testing.T) {
+		require.NoError(t, NewPostgresUserStore(db).DeleteUser(ctx, int64(user.ID)))
+
+		var rows int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM user_preferences WHERE user_id = $1`, user.ID).Scan(&rows))
+		assert.Zero(t, rows)
+	})
+}
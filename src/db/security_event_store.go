@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Security events recorded for accounts.
+const (
+	SecurityEventRegistered        = "user.registered"
+	SecurityEventLoginSucceeded    = "login.succeeded"
+	SecurityEventLoginFailed       = "login.failed"
+	SecurityEventTokenIssued       = "token.issued"
+	SecurityEventTokenRevoked      = "token.revoked"
+	SecurityEventPasswordChanged   = "password.changed"
+	SecurityEventEmailChanged      = "email.changed"
+	SecurityEventUsernameChanged   = "username.changed"
+	SecurityEventTwoFactorEnabled  = "two_factor.enabled"
+	SecurityEventTwoFactorDisabled = "two_factor.disabled"
+	SecurityEventAccountDeleted    = "account.deleted"
+	SecurityEventAccountRestored   = "account.restored"
+)
+
+// SecurityEvent is an entry in an account's security log. UserID is the
+// account the event concerns and ActorID who caused it; either is nil when
+// unknown, such as a failed login for an email nobody has registered.
+type SecurityEvent struct {
+	ID        int64          `json:"id"`
+	UserID    *int           `json:"user_id"`
+	ActorID   *int           `json:"actor_id"`
+	Event     string         `json:"event"`
+	Details   map[string]any `json:"details"`
+	IP        string         `json:"ip"`
+	UserAgent string         `json:"user_agent"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type PostgresSecurityEventStore struct {
+	db *sql.DB
+}
+
+func NewPostgresSecurityEventStore(db *sql.DB) *PostgresSecurityEventStore {
+	return &PostgresSecurityEventStore{db: db}
+}
+
+// SecurityEventStore keeps the append-only security log. The table refuses
+// updates and deletes, so there are no methods for them.
+type SecurityEventStore interface {
+	RecordEvent(ctx context.Context, event *SecurityEvent) error
+	// ListEvents lists events newest first. A userID of 0 lists every
+	// user's events and an empty event name every kind.
+	ListEvents(ctx context.Context, userID int64, event string, limit, offset int) ([]*SecurityEvent, error)
+}
+
+func (pg *PostgresSecurityEventStore) RecordEvent(ctx context.Context, event *SecurityEvent) error {
+	details := event.Details
+	if details == nil {
+		details = map[string]any{}
+	}
+
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO security_events (user_id, actor_id, event, details, ip, user_agent)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+
+	return pg.db.QueryRowContext(ctx, query,
+		event.UserID,
+		event.ActorID,
+		event.Event,
+		string(encoded),
+		event.IP,
+		event.UserAgent,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (pg *PostgresSecurityEventStore) ListEvents(ctx context.Context, userID int64, event string, limit, offset int) ([]*SecurityEvent, error) {
+	query := `
+	SELECT id, user_id, actor_id, event, details, ip, user_agent, created_at
+	FROM security_events
+	WHERE ($1::BIGINT = 0 OR user_id = $1) AND ($2 = '' OR event = $2)
+	ORDER BY created_at DESC, id DESC
+	LIMIT $3 OFFSET $4
+	`
+
+	rows, err := pg.db.QueryContext(ctx, query, userID, event, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*SecurityEvent{}
+	for rows.Next() {
+		event := &SecurityEvent{}
+		var details []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.UserID,
+			&event.ActorID,
+			&event.Event,
+			&details,
+			&event.IP,
+			&event.UserAgent,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
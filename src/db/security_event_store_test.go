@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityEventStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresSecurityEventStore(db)
+	ctx := context.Background()
+
+	_, err := db.Exec(`TRUNCATE security_events`)
+	require.NoError(t, err)
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+
+	require.NoError(t, store.RecordEvent(ctx, &SecurityEvent{UserID: &user.ID, ActorID: &user.ID, Event: SecurityEventRegistered}))
+	require.NoError(t, store.RecordEvent(ctx, &SecurityEvent{
+		UserID:    &user.ID,
+		Event:     SecurityEventLoginFailed,
+		Details:   map[string]any{"reason": "wrong_password"},
+		IP:        "192.0.2.1",
+		UserAgent: "curl/8.0",
+	}))
+	require.NoError(t, store.RecordEvent(ctx, &SecurityEvent{UserID: &other.ID, ActorID: &other.ID, Event: SecurityEventRegistered}))
+	require.NoError(t, store.RecordEvent(ctx, &SecurityEvent{Event: SecurityEventLoginFailed}))
+
+	t.Run("Lists newest first", func(t *testing.T) {
+		events, err := store.ListEvents(ctx, int64(user.ID), "", 10, 0)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		assert.Equal(t, SecurityEventLoginFailed, events[0].Event)
+		assert.Equal(t, map[string]any{"reason": "wrong_password"}, events[0].Details)
+		assert.Equal(t, "192.0.2.1", events[0].IP)
+		assert.Equal(t, "curl/8.0", events[0].UserAgent)
+		assert.Nil(t, events[0].ActorID)
+
+		assert.Equal(t, SecurityEventRegistered, events[1].Event)
+		assert.Empty(t, events[1].Details)
+		require.NotNil(t, events[1].ActorID)
+		assert.Equal(t, user.ID, *events[1].ActorID)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		events, err := store.ListEvents(ctx, 0, "", 10, 0)
+		require.NoError(t, err)
+		assert.Len(t, events, 4)
+
+		events, err = store.ListEvents(ctx, 0, SecurityEventLoginFailed, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Nil(t, events[0].UserID)
+
+		events, err = store.ListEvents(ctx, 0, SecurityEventRegistered, 1, 1)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, user.ID, *events[0].UserID)
+	})
+
+	t.Run("Append-only", func(t *testing.T) {
+		_, err := db.Exec(`UPDATE security_events SET event = 'tampered'`)
+		assert.Error(t, err)
+
+		_, err = db.Exec(`DELETE FROM security_events WHERE user_id = $1`, user.ID)
+		assert.Error(t, err)
+
+		events, err := store.ListEvents(ctx, 0, "", 10, 0)
+		require.NoError(t, err)
+		assert.Len(t, events, 4)
+	})
+}
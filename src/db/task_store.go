@@ -4,10 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var ErrTaskNotFound = errors.New("task not found")
 
+// Task is an item on a user's checklist. It is due either at a time, DueDate,
+// or at any time on a calendar date, DueOn, such as "2025-06-01". DueOn is
+// not tied to a time zone, so which day it is for the user is decided by their
+// time zone at the time of asking.
 type Task struct {
 	ID          int          `json:"id"`
 	UserID      int          `json:"user_id"`
@@ -16,10 +21,42 @@ type Task struct {
 	Category    string       `json:"category"`
 	IsComplete  bool         `json:"is_complete"`
 	DueDate     sql.NullTime `json:"due_date"`
+	DueOn       *string      `json:"due_on"`
 	CreatedAt   sql.NullTime `json:"created_at"`
 	UpdatedAt   sql.NullTime `json:"updated_at"`
 }
 
+// DueStatus reports whether the task is overdue or due today as of now, by
+// the rules CountTasksForUser counts with. Completed tasks are neither.
+func (t *Task) DueStatus(now time.Time) (overdue, dueToday bool) {
+	if t.IsComplete {
+		return false, false
+	}
+
+	if t.DueOn != nil {
+		today := now.Format(time.DateOnly)
+		return *t.DueOn < today, *t.DueOn == today
+	}
+
+	if !t.DueDate.Valid {
+		return false, false
+	}
+
+	year, month, day := now.Date()
+	tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	overdue = t.DueDate.Time.Before(now)
+	return overdue, !overdue && t.DueDate.Time.Before(tomorrow)
+}
+
+// TaskCounts summarises a user's checklist. Overdue and due today tasks are
+// also open, and no task is both.
+type TaskCounts struct {
+	Open      int `json:"open"`
+	Completed int `json:"completed"`
+	Overdue   int `json:"overdue"`
+	DueToday  int `json:"due_today"`
+}
+
 type PostgresTaskStore struct {
 	db *sql.DB
 }
@@ -34,6 +71,10 @@ type TaskStore interface {
 	UpdateTask(ctx context.Context, task *Task) error
 	GetTaskByID(ctx context.Context, id int64, userID int) (*Task, error)
 	GetTasksByUserID(ctx context.Context, userID int) ([]*Task, error)
+	EachTaskForUser(ctx context.Context, userID int, fn func(*Task) error) error
+	// CountTasksForUser counts the user's tasks as of now, whose location
+	// decides when the user's day starts and ends.
+	CountTasksForUser(ctx context.Context, userID int, now time.Time) (*TaskCounts, error)
 }
 
 func (pg *PostgresTaskStore) CreateTask(ctx context.Context, task *Task) (*Task, error) {
@@ -45,8 +86,8 @@ func (pg *PostgresTaskStore) CreateTask(ctx context.Context, task *Task) (*Task,
 	defer transaction.Rollback()
 
 	query := `
-	INSERT INTO tasks (user_id, name, description, category, is_complete, due_date, created_at, updated_at)
-	VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	INSERT INTO tasks (user_id, name, description, category, is_complete, due_date, due_on, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7::DATE, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	RETURNING id
 	`
 
@@ -57,6 +98,7 @@ func (pg *PostgresTaskStore) CreateTask(ctx context.Context, task *Task) (*Task,
 		task.Category,
 		task.IsComplete,
 		task.DueDate,
+		task.DueOn,
 	).Scan(&task.ID)
 
 	if err != nil {
@@ -103,8 +145,8 @@ func (pg *PostgresTaskStore) UpdateTask(ctx context.Context, task *Task) error {
 
 	query := `
 	UPDATE tasks
-	SET name = $1, description = $2, category = $3, is_complete = $4, due_date = $5, updated_at = $6
-	WHERE id = $7 and user_id = $8
+	SET name = $1, description = $2, category = $3, is_complete = $4, due_date = $5, due_on = $6::DATE, updated_at = $7
+	WHERE id = $8 and user_id = $9
 	`
 
 	result, err := transaction.ExecContext(ctx, query,
@@ -113,6 +155,7 @@ func (pg *PostgresTaskStore) UpdateTask(ctx context.Context, task *Task) error {
 		task.Category,
 		task.IsComplete,
 		task.DueDate,
+		task.DueOn,
 		task.UpdatedAt,
 		task.ID,
 		task.UserID,
@@ -143,7 +186,7 @@ func (pg *PostgresTaskStore) GetTaskByID(ctx context.Context, id int64, userID i
 	task := &Task{}
 
 	query := `
-	SELECT id, user_id, name, description, category, is_complete, due_date, created_at, updated_at
+	SELECT id, user_id, name, description, category, is_complete, due_date, to_char(due_on, 'YYYY-MM-DD'), created_at, updated_at
 	FROM tasks
 	WHERE id = $1 AND user_id = $2
 	`
@@ -156,6 +199,7 @@ func (pg *PostgresTaskStore) GetTaskByID(ctx context.Context, id int64, userID i
 		&task.Category,
 		&task.IsComplete,
 		&task.DueDate,
+		&task.DueOn,
 		&task.CreatedAt,
 		&task.UpdatedAt,
 	)
@@ -173,7 +217,7 @@ func (pg *PostgresTaskStore) GetTaskByID(ctx context.Context, id int64, userID i
 
 func (pg *PostgresTaskStore) GetTasksByUserID(ctx context.Context, userID int) ([]*Task, error) {
 	query := `
-	SELECT id, user_id, name, description, category, is_complete, due_date, created_at, updated_at
+	SELECT id, user_id, name, description, category, is_complete, due_date, to_char(due_on, 'YYYY-MM-DD'), created_at, updated_at
 	FROM tasks
 	WHERE user_id = $1
 	ORDER BY created_at DESC
@@ -197,6 +241,7 @@ func (pg *PostgresTaskStore) GetTasksByUserID(ctx context.Context, userID int) (
 			&task.Category,
 			&task.IsComplete,
 			&task.DueDate,
+			&task.DueOn,
 			&task.CreatedAt,
 			&task.UpdatedAt,
 		)
@@ -208,3 +253,73 @@ func (pg *PostgresTaskStore) GetTasksByUserID(ctx context.Context, userID int) (
 
 	return tasks, nil
 }
+
+// EachTaskForUser calls fn for each of the user's tasks, oldest first, without
+// loading them all into memory. It stops at the first error fn returns.
+func (pg *PostgresTaskStore) EachTaskForUser(ctx context.Context, userID int, fn func(*Task) error) error {
+	query := `
+	SELECT id, user_id, name, description, category, is_complete, due_date, to_char(due_on, 'YYYY-MM-DD'), created_at, updated_at
+	FROM tasks
+	WHERE user_id = $1
+	ORDER BY created_at, id
+	`
+
+	rows, err := pg.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		task := &Task{}
+		err := rows.Scan(
+			&task.ID,
+			&task.UserID,
+			&task.Name,
+			&task.Description,
+			&task.Category,
+			&task.IsComplete,
+			&task.DueDate,
+			&task.DueOn,
+			&task.CreatedAt,
+			&task.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(task)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// CountTasksForUser treats a task due at a time as overdue from that time,
+// and one due on a date only once that date is over where now is.
+func (pg *PostgresTaskStore) CountTasksForUser(ctx context.Context, userID int, now time.Time) (*TaskCounts, error) {
+	counts := &TaskCounts{}
+
+	year, month, day := now.Date()
+	tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+
+	query := `
+	SELECT
+		COUNT(*) FILTER (WHERE is_complete IS NOT TRUE),
+		COUNT(*) FILTER (WHERE is_complete IS TRUE),
+		COUNT(*) FILTER (WHERE is_complete IS NOT TRUE AND (due_date < $2::TIMESTAMPTZ OR due_on < $3::DATE)),
+		COUNT(*) FILTER (WHERE is_complete IS NOT TRUE AND (due_date >= $2::TIMESTAMPTZ AND due_date < $4::TIMESTAMPTZ OR due_on = $3::DATE))
+	FROM tasks
+	WHERE user_id = $1
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, userID, now, now.Format(time.DateOnly), tomorrow).Scan(&counts.Open, &counts.Completed, &counts.Overdue, &counts.DueToday)
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -276,6 +276,154 @@ func TestGetTaskByID(t *testing.T) {
 	}
 }
 
+func TestCountTasksForUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+	store := NewPostgresTaskStore(db)
+	ctx := context.Background()
+
+	counts, err := store.CountTasksForUser(ctx, user.ID, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, TaskCounts{}, *counts)
+
+	open := validTask("Open task", user.ID)
+	completed := validTask("Completed task", user.ID)
+	completed.IsComplete = true
+	overdue := validTask("Overdue task", user.ID)
+	overdue.DueDate = sql.NullTime{Time: time.Now().Add(-48 * time.Hour), Valid: true}
+	completedLate := validTask("Completed late task", user.ID)
+	completedLate.IsComplete = true
+	completedLate.DueDate = overdue.DueDate
+
+	for _, task := range []*Task{open, completed, overdue, completedLate, validTask("Someone else's task", other.ID)} {
+		_, err := store.CreateTask(ctx, task)
+		require.NoError(t, err)
+	}
+
+	counts, err = store.CountTasksForUser(ctx, user.ID, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, TaskCounts{Open: 2, Completed: 2, Overdue: 1}, *counts)
+
+	t.Run("Days follow the user's time zone", func(t *testing.T) {
+		denver, err := time.LoadLocation("America/Denver")
+		require.NoError(t, err)
+
+		traveller := createTestUser(t, db)
+		dueAt := func(name string, at time.Time) *Task {
+			task := validTask(name, traveller.ID)
+			task.DueDate = sql.NullTime{Time: at, Valid: true}
+			return task
+		}
+		dueOn := func(name, date string) *Task {
+			task := validTask(name, traveller.ID)
+			task.DueDate = sql.NullTime{}
+			task.DueOn = &date
+			return task
+		}
+
+		for _, task := range []*Task{
+			dueOn("Due yesterday", "2025-05-31"),
+			dueOn("Due today", "2025-06-01"),
+			dueOn("Due tomorrow", "2025-06-02"),
+			dueAt("Due this evening", time.Date(2025, 6, 1, 19, 0, 0, 0, denver)),
+			dueAt("Due tonight", time.Date(2025, 6, 1, 21, 0, 0, 0, denver)),
+			dueAt("Due overnight", time.Date(2025, 6, 2, 1, 0, 0, 0, denver)),
+		} {
+			_, err := store.CreateTask(ctx, task)
+			require.NoError(t, err)
+		}
+
+		// 8pm on June 1 in Denver is already June 2 in UTC
+		now := time.Date(2025, 6, 1, 20, 0, 0, 0, denver)
+
+		counts, err := store.CountTasksForUser(ctx, traveller.ID, now)
+		require.NoError(t, err)
+		assert.Equal(t, TaskCounts{Open: 6, Overdue: 2, DueToday: 2}, *counts)
+
+		// After a move to UTC the dates are the same days, taken in UTC
+		counts, err = store.CountTasksForUser(ctx, traveller.ID, now.UTC())
+		require.NoError(t, err)
+		assert.Equal(t, TaskCounts{Open: 6, Overdue: 3, DueToday: 3}, *counts)
+	})
+
+	t.Run("Due on round trip", func(t *testing.T) {
+		date := "2025-06-01"
+		task := validTask("All day", user.ID)
+		task.DueDate = sql.NullTime{}
+		task.DueOn = &date
+		created, err := store.CreateTask(ctx, task)
+		require.NoError(t, err)
+
+		fetched, err := store.GetTaskByID(ctx, int64(created.ID), user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, fetched.DueOn)
+		assert.Equal(t, "2025-06-01", *fetched.DueOn)
+		assert.False(t, fetched.DueDate.Valid)
+
+		// A task is due at a time or on a date, not both
+		fetched.DueDate = sql.NullTime{Time: time.Now(), Valid: true}
+		assert.Error(t, store.UpdateTask(ctx, fetched))
+
+		fetched.DueDate = sql.NullTime{}
+		fetched.DueOn = nil
+		require.NoError(t, store.UpdateTask(ctx, fetched))
+
+		fetched, err = store.GetTaskByID(ctx, int64(created.ID), user.ID)
+		require.NoError(t, err)
+		assert.Nil(t, fetched.DueOn)
+	})
+}
+
+func TestTaskDueStatus(t *testing.T) {
+	denver, err := time.LoadLocation("America/Denver")
+	require.NoError(t, err)
+
+	dueAt := func(at time.Time) *Task {
+		return &Task{DueDate: sql.NullTime{Time: at, Valid: true}}
+	}
+	dueOn := func(date string) *Task {
+		return &Task{DueOn: &date}
+	}
+	completed := dueOn("2025-05-31")
+	completed.IsComplete = true
+
+	// 8pm on June 1 in Denver is already June 2 in UTC
+	now := time.Date(2025, 6, 1, 20, 0, 0, 0, denver)
+
+	tests := []struct {
+		name        string
+		task        *Task
+		overdue     bool
+		dueToday    bool
+		overdueUTC  bool
+		dueTodayUTC bool
+	}{
+		{"No due date", &Task{}, false, false, false, false},
+		{"Completed", completed, false, false, false, false},
+		{"Due yesterday", dueOn("2025-05-31"), true, false, true, false},
+		{"Due today", dueOn("2025-06-01"), false, true, true, false},
+		{"Due tomorrow", dueOn("2025-06-02"), false, false, false, true},
+		{"Due this evening", dueAt(time.Date(2025, 6, 1, 19, 0, 0, 0, denver)), true, false, true, false},
+		{"Due tonight", dueAt(time.Date(2025, 6, 1, 21, 0, 0, 0, denver)), false, true, false, true},
+		{"Due overnight", dueAt(time.Date(2025, 6, 2, 1, 0, 0, 0, denver)), false, false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overdue, dueToday := tt.task.DueStatus(now)
+			assert.Equal(t, tt.overdue, overdue, "overdue in Denver")
+			assert.Equal(t, tt.dueToday, dueToday, "due today in Denver")
+
+			overdue, dueToday = tt.task.DueStatus(now.UTC())
+			assert.Equal(t, tt.overdueUTC, overdue, "overdue in UTC")
+			assert.Equal(t, tt.dueTodayUTC, dueToday, "due today in UTC")
+		})
+	}
+}
+
 func validTask(name string, userID int) *Task {
 	return &Task{
 		UserID:      userID,
@@ -304,3 +452,35 @@ func createTestUser(t *testing.T, db *sql.DB) *User {
 
 	return createdUser
 }
+
+func TestEachTaskForUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+	store := NewPostgresTaskStore(db)
+	ctx := context.Background()
+
+	for _, task := range []*Task{validTask("First", user.ID), validTask("Second", user.ID), validTask("Not mine", other.ID)} {
+		_, err := store.CreateTask(ctx, task)
+		require.NoError(t, err)
+	}
+
+	var names []string
+	err := store.EachTaskForUser(ctx, user.ID, func(task *Task) error {
+		names = append(names, task.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"First", "Second"}, names)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.EachTaskForUser(ctx, user.ID, func(task *Task) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
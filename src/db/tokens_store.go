@@ -2,18 +2,53 @@ package db
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/base64"
+	"errors"
 	"time"
+
+	"github.com/trevortippery/moving-checklist/tokens"
 )
 
-type Token struct {
-	UserID int64
-	Token  string
-	Expiry time.Time
-	Scope  string
+// ErrTokenReused is returned when a refresh token that was already exchanged is
+// presented again. Its whole family has been revoked by the time it is returned.
+var ErrTokenReused = errors.New("refresh token reused")
+
+var ErrSessionNotFound = errors.New("session not found")
+
+const tokenColumns = `id, hash, user_id, expiry, scope, COALESCE(family, ''), created_at, last_used_at, user_agent, ip, device_name`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(row rowScanner, extra ...any) (*tokens.Token, error) {
+	token := &tokens.Token{}
+	var lastUsedAt sql.NullTime
+
+	dest := []any{
+		&token.ID,
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.Family,
+		&token.CreatedAt,
+		&lastUsedAt,
+		&token.UserAgent,
+		&token.IP,
+		&token.DeviceName,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return token, nil
 }
 
 type PostgresTokenStore struct {
@@ -21,39 +56,344 @@ type PostgresTokenStore struct {
 }
 
 type TokenStore interface {
-	GenerateToken(ctx context.Context, userID int64, ttl time.Duration, scope string) (string, error)
+	GenerateToken(ctx context.Context, userID int64, ttl time.Duration, scope string) (*tokens.Token, error)
+	Insert(ctx context.Context, token *tokens.Token) error
+	GetToken(ctx context.Context, plaintext string, scope string) (*tokens.Token, error)
+	ListTokensForUser(ctx context.Context, userID int64, scope string) ([]*tokens.Token, error)
+	ListSessionsForUser(ctx context.Context, userID int64) ([]*tokens.Token, error)
+	GetSessionByID(ctx context.Context, userID int64, id int64) (*tokens.Token, error)
+	TokenExpired(ctx context.Context, plaintext string, scope string) (bool, error)
+	TouchToken(ctx context.Context, plaintext string, interval time.Duration) error
+	ConsumeRefreshToken(ctx context.Context, plaintext string) (*tokens.Token, error)
+	DeleteToken(ctx context.Context, plaintext string) error
+	DeleteTokenFamily(ctx context.Context, family string) (int64, error)
+	DeleteSession(ctx context.Context, userID int64, id int64) error
+	DeleteAllTokensForUser(ctx context.Context, userID int64, scopes ...string) (int64, error)
+	DeleteExpired(ctx context.Context, limit int) (int64, error)
+	DeleteOtherTokensForUser(ctx context.Context, userID int64, scope string, keep *tokens.Token) (int64, error)
 }
 
 func NewPostgresTokenStore(db *sql.DB) *PostgresTokenStore {
 	return &PostgresTokenStore{db: db}
 }
 
-func (ts *PostgresTokenStore) GenerateToken(ctx context.Context, userID int64, ttl time.Duration, scope string) (string, error) {
+func (ts *PostgresTokenStore) GenerateToken(ctx context.Context, userID int64, ttl time.Duration, scope string) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(int(userID), ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ts.Insert(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (ts *PostgresTokenStore) Insert(ctx context.Context, token *tokens.Token) error {
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, family, user_agent, ip, device_name)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+	RETURNING id, created_at
+	`
+
+	return ts.db.QueryRowContext(ctx, query,
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		token.Family,
+		token.UserAgent,
+		token.IP,
+		token.DeviceName,
+	).Scan(&token.ID, &token.CreatedAt)
+}
+
+// GetToken returns the unexpired token row matching plaintext, or nil if the
+// token is unknown, expired or has been revoked.
+func (ts *PostgresTokenStore) GetToken(ctx context.Context, plaintext string, scope string) (*tokens.Token, error) {
+	query := `
+	SELECT ` + tokenColumns + `
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > CURRENT_TIMESTAMP
+	`
+
+	token, err := scanToken(ts.db.QueryRowContext(ctx, query, tokens.HashToken(plaintext), scope))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (ts *PostgresTokenStore) ListTokensForUser(ctx context.Context, userID int64, scope string) ([]*tokens.Token, error) {
+	query := `
+	SELECT ` + tokenColumns + `
+	FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND expiry > CURRENT_TIMESTAMP
+	ORDER BY created_at DESC
+	`
+
+	return ts.queryTokens(ctx, query, userID, scope)
+}
+
+// ListSessionsForUser returns one auth token per sign-in: the most recent one
+// issued to each token family, newest session first.
+func (ts *PostgresTokenStore) ListSessionsForUser(ctx context.Context, userID int64) ([]*tokens.Token, error) {
+	query := `
+	SELECT ` + tokenColumns + `
+	FROM (
+		SELECT DISTINCT ON (COALESCE(family, encode(hash, 'hex'))) *
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND expiry > CURRENT_TIMESTAMP
+		ORDER BY COALESCE(family, encode(hash, 'hex')), created_at DESC
+	) sessions
+	ORDER BY created_at DESC
+	`
+
+	return ts.queryTokens(ctx, query, userID, tokens.ScopeAuth)
+}
+
+func (ts *PostgresTokenStore) GetSessionByID(ctx context.Context, userID int64, id int64) (*tokens.Token, error) {
+	query := `
+	SELECT ` + tokenColumns + `
+	FROM tokens
+	WHERE id = $1 AND user_id = $2 AND scope = $3 AND expiry > CURRENT_TIMESTAMP
+	`
+
+	token, err := scanToken(ts.db.QueryRowContext(ctx, query, id, userID, tokens.ScopeAuth))
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (ts *PostgresTokenStore) queryTokens(ctx context.Context, query string, args ...any) ([]*tokens.Token, error) {
+	rows, err := ts.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var userTokens []*tokens.Token
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		userTokens = append(userTokens, token)
+	}
+
+	return userTokens, rows.Err()
+}
+
+// TokenExpired reports whether the token was issued with the given scope and
+// has expired but not yet been swept away.
+func (ts *PostgresTokenStore) TokenExpired(ctx context.Context, plaintext string, scope string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry <= CURRENT_TIMESTAMP
+	)
+	`
+
+	var expired bool
+	err := ts.db.QueryRowContext(ctx, query, tokens.HashToken(plaintext), scope).Scan(&expired)
+	return expired, err
+}
+
+// TouchToken records that a token was just used. Writes are throttled so a
+// busy client updates last_used_at at most once per interval.
+func (ts *PostgresTokenStore) TouchToken(ctx context.Context, plaintext string, interval time.Duration) error {
+	query := `
+	UPDATE tokens
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE hash = $1
+	AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+	`
+
+	_, err := ts.db.ExecContext(ctx, query, tokens.HashToken(plaintext), interval.Seconds())
+	return err
+}
+
+// ConsumeRefreshToken marks an unexpired refresh token as used and returns it.
+// It returns nil if the token is unknown or expired, and ErrTokenReused if the
+// token was already consumed, in which case its entire family is revoked and
+// the reused token is returned along with the error to show whose it was.
+func (ts *PostgresTokenStore) ConsumeRefreshToken(ctx context.Context, plaintext string) (*tokens.Token, error) {
+	transaction, err := ts.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer transaction.Rollback()
+
+	var usedAt sql.NullTime
+
+	query := `
+	SELECT ` + tokenColumns + `, used_at
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > CURRENT_TIMESTAMP
+	FOR UPDATE
+	`
+
+	row := transaction.QueryRowContext(ctx, query, tokens.HashToken(plaintext), tokens.ScopeRefresh)
+	token, err := scanToken(row, &usedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 
-	tokenBytes := make([]byte, 32)
-	_, err := rand.Read(tokenBytes)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	rawToken := base64.URLEncoding.EncodeToString(tokenBytes)
+	if usedAt.Valid {
+		_, err = transaction.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, token.Family)
+		if err != nil {
+			return nil, err
+		}
 
-	hash := sha256.Sum256([]byte(rawToken))
-	hashedToken := base64.URLEncoding.EncodeToString(hash[:])
+		err = transaction.Commit()
+		if err != nil {
+			return nil, err
+		}
 
-	expiry := time.Now().Add(ttl)
+		return token, ErrTokenReused
+	}
+
+	_, err = transaction.ExecContext(ctx, `UPDATE tokens SET used_at = CURRENT_TIMESTAMP WHERE hash = $1`, token.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (ts *PostgresTokenStore) DeleteToken(ctx context.Context, plaintext string) error {
+	query := `DELETE FROM tokens WHERE hash = $1`
+
+	result, err := ts.db.ExecContext(ctx, query, tokens.HashToken(plaintext))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
 
+	return nil
+}
+
+// DeleteTokenFamily revokes every token issued from the same sign-in.
+func (ts *PostgresTokenStore) DeleteTokenFamily(ctx context.Context, family string) (int64, error) {
+	query := `DELETE FROM tokens WHERE family = $1`
+
+	result, err := ts.db.ExecContext(ctx, query, family)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteSession signs out one of the user's sessions by revoking the auth token
+// with the given id together with every token from the same sign-in.
+func (ts *PostgresTokenStore) DeleteSession(ctx context.Context, userID int64, id int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $1 AND (
+		id = $2 OR family = (SELECT family FROM tokens WHERE id = $2 AND user_id = $1)
+	)
+	AND EXISTS (SELECT 1 FROM tokens WHERE id = $2 AND user_id = $1 AND scope = $3)
+	`
+
+	result, err := ts.db.ExecContext(ctx, query, userID, id, tokens.ScopeAuth)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllTokensForUser revokes every token of the given scopes belonging to
+// the user in one statement and reports how many were removed.
+func (ts *PostgresTokenStore) DeleteAllTokensForUser(ctx context.Context, userID int64, scopes ...string) (int64, error) {
+	query := `DELETE FROM tokens WHERE user_id = $1 AND scope = ANY($2)`
+
+	result, err := ts.db.ExecContext(ctx, query, userID, scopes)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpired removes up to limit tokens that have expired, of any scope,
+// and reports how many it removed. Callers repeat it until it removes fewer
+// than limit, so no single statement holds locks on a large part of the table.
+func (ts *PostgresTokenStore) DeleteExpired(ctx context.Context, limit int) (int64, error) {
 	query := `
-		INSERT INTO tokens (token, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+	DELETE FROM tokens
+	WHERE id IN (
+		SELECT id FROM tokens
+		WHERE expiry <= CURRENT_TIMESTAMP
+		LIMIT $1
+	)
 	`
 
-	_, err = ts.db.ExecContext(ctx, query, hashedToken, userID, expiry, scope)
+	result, err := ts.db.ExecContext(ctx, query, limit)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 
-	return rawToken, nil
+	return result.RowsAffected()
 }
 
-// (Optional) You can add methods like DeleteToken, GetToken, etc.
+// DeleteOtherTokensForUser revokes the user's tokens of the given scope except
+// keep and any token issued from the same sign-in as keep.
+func (ts *PostgresTokenStore) DeleteOtherTokensForUser(ctx context.Context, userID int64, scope string, keep *tokens.Token) (int64, error) {
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND hash IS DISTINCT FROM $3
+	AND family IS DISTINCT FROM $4
+	`
+
+	result, err := ts.db.ExecContext(ctx, query, userID, scope, keep.Hash, keep.Family)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
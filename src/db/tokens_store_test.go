@@ -0,0 +1,317 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+func TestGenerateToken(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		scope     string
+		lookup    string
+		wantFound bool
+	}{
+		{
+			name:      "Valid token resolves to its user",
+			ttl:       time.Hour,
+			scope:     tokens.ScopeAuth,
+			lookup:    tokens.ScopeAuth,
+			wantFound: true,
+		},
+		{
+			name:      "Token does not resolve for another scope",
+			ttl:       time.Hour,
+			scope:     tokens.ScopeAuth,
+			lookup:    "other",
+			wantFound: false,
+		},
+		{
+			name:      "Expired token does not resolve",
+			ttl:       -time.Hour,
+			scope:     tokens.ScopeAuth,
+			lookup:    tokens.ScopeAuth,
+			wantFound: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tokenStore.GenerateToken(ctx, int64(user.ID), tt.ttl, tt.scope)
+			require.NoError(t, err)
+			require.NotNil(t, token)
+			assert.NotEmpty(t, token.Plaintext)
+
+			found, err := userStore.GetUserByToken(ctx, token.Plaintext, tt.lookup)
+			require.NoError(t, err)
+
+			if tt.wantFound {
+				require.NotNil(t, found)
+				assert.Equal(t, user.ID, found.ID)
+			} else {
+				assert.Nil(t, found)
+			}
+		})
+	}
+}
+
+func TestDeleteToken(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+	ctx := context.Background()
+
+	token, err := tokenStore.GenerateToken(ctx, int64(user.ID), time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	stored, err := tokenStore.GetToken(ctx, token.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, user.ID, stored.UserID)
+
+	err = tokenStore.DeleteToken(ctx, token.Plaintext)
+	require.NoError(t, err)
+
+	found, err := userStore.GetUserByToken(ctx, token.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Nil(t, found, "revoked token must not authenticate")
+
+	err = tokenStore.DeleteToken(ctx, token.Plaintext)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestDeleteAllTokensForUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := tokenStore.GenerateToken(ctx, int64(user.ID), time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+	}
+	_, err := tokenStore.GenerateToken(ctx, int64(user.ID), time.Hour, tokens.ScopeRefresh)
+	require.NoError(t, err)
+	_, err = tokenStore.GenerateToken(ctx, int64(user.ID), time.Hour, tokens.ScopePasswordReset)
+	require.NoError(t, err)
+	_, err = tokenStore.GenerateToken(ctx, int64(other.ID), time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	userTokens, err := tokenStore.ListTokensForUser(ctx, int64(user.ID), tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Len(t, userTokens, 3)
+
+	revoked, err := tokenStore.DeleteAllTokensForUser(ctx, int64(user.ID), tokens.ScopeAuth, tokens.ScopeRefresh)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), revoked)
+
+	userTokens, err = tokenStore.ListTokensForUser(ctx, int64(user.ID), tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Empty(t, userTokens)
+
+	userTokens, err = tokenStore.ListTokensForUser(ctx, int64(user.ID), tokens.ScopePasswordReset)
+	require.NoError(t, err)
+	assert.Len(t, userTokens, 1, "other scopes must be untouched")
+
+	userTokens, err = tokenStore.ListTokensForUser(ctx, int64(other.ID), tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Len(t, userTokens, 1, "other users' tokens must be untouched")
+}
+
+func TestConsumeRefreshToken(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	family, err := tokens.NewFamily()
+	require.NoError(t, err)
+
+	authToken, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	authToken.Family = family
+	require.NoError(t, tokenStore.Insert(ctx, authToken))
+
+	refreshToken, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeRefresh)
+	require.NoError(t, err)
+	refreshToken.Family = family
+	require.NoError(t, tokenStore.Insert(ctx, refreshToken))
+
+	consumed, err := tokenStore.ConsumeRefreshToken(ctx, refreshToken.Plaintext)
+	require.NoError(t, err)
+	require.NotNil(t, consumed)
+	assert.Equal(t, family, consumed.Family)
+	assert.Equal(t, user.ID, consumed.UserID)
+
+	reused, err := tokenStore.ConsumeRefreshToken(ctx, refreshToken.Plaintext)
+	assert.ErrorIs(t, err, ErrTokenReused)
+	require.NotNil(t, reused)
+	assert.Equal(t, user.ID, reused.UserID)
+
+	stored, err := tokenStore.GetToken(ctx, authToken.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Nil(t, stored, "reuse must revoke the whole family")
+
+	consumed, err = tokenStore.ConsumeRefreshToken(ctx, "not-a-real-token")
+	require.NoError(t, err)
+	assert.Nil(t, consumed)
+}
+
+func TestDeleteOtherTokensForUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	current, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	current.Family = "current-family"
+	require.NoError(t, tokenStore.Insert(ctx, current))
+
+	sibling, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeRefresh)
+	require.NoError(t, err)
+	sibling.Family = "current-family"
+	require.NoError(t, tokenStore.Insert(ctx, sibling))
+
+	for i := 0; i < 2; i++ {
+		_, err := tokenStore.GenerateToken(ctx, int64(user.ID), time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+	}
+
+	revoked, err := tokenStore.DeleteOtherTokensForUser(ctx, int64(user.ID), tokens.ScopeAuth, current)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), revoked)
+
+	revoked, err = tokenStore.DeleteOtherTokensForUser(ctx, int64(user.ID), tokens.ScopeRefresh, current)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), revoked, "refresh token from the same sign-in is kept")
+
+	stored, err := tokenStore.GetToken(ctx, current.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.NotNil(t, stored)
+}
+
+func TestSessions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	newSession := func(family, device string) *tokens.Token {
+		token, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+		token.Family = family
+		token.DeviceName = device
+		token.UserAgent = "test-agent"
+		token.IP = "127.0.0.1"
+		require.NoError(t, tokenStore.Insert(ctx, token))
+		return token
+	}
+
+	laptop := newSession("laptop-family", "Laptop")
+	newSession("phone-family", "Phone")
+	rotated := newSession("phone-family", "Phone")
+
+	sessions, err := tokenStore.ListSessionsForUser(ctx, int64(user.ID))
+	require.NoError(t, err)
+	require.Len(t, sessions, 2, "one session per token family")
+	assert.Equal(t, rotated.ID, sessions[0].ID)
+	assert.Equal(t, "Phone", sessions[0].DeviceName)
+	assert.Nil(t, sessions[0].LastUsedAt)
+
+	err = tokenStore.TouchToken(ctx, laptop.Plaintext, time.Minute)
+	require.NoError(t, err)
+
+	touched, err := tokenStore.GetSessionByID(ctx, int64(user.ID), laptop.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, touched.LastUsedAt)
+
+	err = tokenStore.DeleteSession(ctx, int64(user.ID), rotated.ID)
+	require.NoError(t, err)
+
+	sessions, err = tokenStore.ListSessionsForUser(ctx, int64(user.ID))
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, laptop.ID, sessions[0].ID)
+
+	err = tokenStore.DeleteSession(ctx, int64(user.ID), rotated.ID)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestDeleteExpiredTokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	var expired *tokens.Token
+	for range 5 {
+		token, err := tokenStore.GenerateToken(ctx, int64(user.ID), -time.Minute, tokens.ScopeAuth)
+		require.NoError(t, err)
+		expired = token
+	}
+
+	live, err := tokenStore.GenerateToken(ctx, int64(user.ID), time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	isExpired, err := tokenStore.TokenExpired(ctx, expired.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.True(t, isExpired)
+
+	isExpired, err = tokenStore.TokenExpired(ctx, live.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.False(t, isExpired)
+
+	isExpired, err = tokenStore.TokenExpired(ctx, "unknown", tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.False(t, isExpired)
+
+	deleted, err := tokenStore.DeleteExpired(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted, "one batch at a time")
+
+	deleted, err = tokenStore.DeleteExpired(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	deleted, err = tokenStore.DeleteExpired(ctx, 3)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	isExpired, err = tokenStore.TokenExpired(ctx, expired.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.False(t, isExpired, "swept tokens are unknown")
+
+	found, err := tokenStore.GetToken(ctx, live.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.NotNil(t, found, "unexpired tokens are kept")
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+// TOTP is a user's authenticator app enrollment. It only protects sign-ins
+// once ConfirmedAt is set.
+type TOTP struct {
+	UserID       int
+	Secret       string
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.ConfirmedAt.Valid
+}
+
+type PostgresTwoFactorStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTwoFactorStore(db *sql.DB) *PostgresTwoFactorStore {
+	return &PostgresTwoFactorStore{db: db}
+}
+
+type TwoFactorStore interface {
+	GetTOTP(ctx context.Context, userID int) (*TOTP, error)
+	CreatePendingTOTP(ctx context.Context, userID int, secret string) error
+	ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryCodes []string) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+	DeleteTOTP(ctx context.Context, userID int) error
+}
+
+// GetTOTP returns the user's enrollment, or nil if they have not started one.
+func (pg *PostgresTwoFactorStore) GetTOTP(ctx context.Context, userID int) (*TOTP, error) {
+	totp := &TOTP{}
+
+	query := `
+	SELECT user_id, secret, confirmed_at, last_used_step, created_at
+	FROM user_totp
+	WHERE user_id = $1
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.ConfirmedAt,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return totp, nil
+}
+
+// CreatePendingTOTP starts an enrollment with a new secret, replacing any
+// earlier unconfirmed one. A confirmed enrollment is left untouched.
+func (pg *PostgresTwoFactorStore) CreatePendingTOTP(ctx context.Context, userID int, secret string) error {
+	query := `
+	INSERT INTO user_totp (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+	WHERE user_totp.confirmed_at IS NULL
+	`
+
+	_, err := pg.db.ExecContext(ctx, query, userID, secret)
+	return err
+}
+
+// ConfirmTOTP enables a pending enrollment and replaces the user's recovery
+// codes, whose plaintexts are only ever held by the caller.
+func (pg *PostgresTwoFactorStore) ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	UPDATE user_totp
+	SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+	WHERE user_id = $1 AND confirmed_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, tokens.HashToken(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was just used. It reports false
+// if that step or a later one was already used, so every code works once.
+func (pg *PostgresTwoFactorStore) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	query := `
+	UPDATE user_totp
+	SET last_used_step = $2
+	WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+	`
+
+	result, err := pg.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether
+// there was one.
+func (pg *PostgresTwoFactorStore) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	query := `
+	UPDATE recovery_codes
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+	`
+
+	result, err := pg.db.ExecContext(ctx, query, userID, tokens.HashToken(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (pg *PostgresTwoFactorStore) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+	err := pg.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteTOTP turns two-factor authentication off and discards the recovery
+// codes.
+func (pg *PostgresTwoFactorStore) DeleteTOTP(ctx context.Context, userID int) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactor(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	store := NewPostgresTwoFactorStore(db)
+	ctx := context.Background()
+
+	enrollment, err := store.GetTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, enrollment.Enabled())
+
+	require.NoError(t, store.CreatePendingTOTP(ctx, user.ID, "FIRSTSECRET"))
+	require.NoError(t, store.CreatePendingTOTP(ctx, user.ID, "SECONDSECRET"))
+
+	enrollment, err = store.GetTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, enrollment)
+	assert.Equal(t, "SECONDSECRET", enrollment.Secret)
+	assert.False(t, enrollment.Enabled())
+
+	// Codes are not accepted until the enrollment is confirmed
+	used, err := store.UseTOTPStep(ctx, user.ID, 100)
+	require.NoError(t, err)
+	assert.False(t, used)
+
+	require.NoError(t, store.ConfirmTOTP(ctx, user.ID, 100, []string{"aaaaa-aaaaa", "bbbbb-bbbbb"}))
+
+	// A confirmed enrollment cannot be replaced by starting a new one
+	require.NoError(t, store.CreatePendingTOTP(ctx, user.ID, "THIRDSECRET"))
+	enrollment, err = store.GetTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, enrollment.Enabled())
+	assert.Equal(t, "SECONDSECRET", enrollment.Secret)
+
+	t.Run("TOTP steps are single use", func(t *testing.T) {
+		used, err := store.UseTOTPStep(ctx, user.ID, 100)
+		require.NoError(t, err)
+		assert.False(t, used)
+
+		used, err = store.UseTOTPStep(ctx, user.ID, 101)
+		require.NoError(t, err)
+		assert.True(t, used)
+
+		used, err = store.UseTOTPStep(ctx, user.ID, 101)
+		require.NoError(t, err)
+		assert.False(t, used)
+	})
+
+	t.Run("Recovery codes are single use", func(t *testing.T) {
+		count, err := store.CountRecoveryCodes(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		used, err := store.UseRecoveryCode(ctx, user.ID, "aaaaa-aaaaa")
+		require.NoError(t, err)
+		assert.True(t, used)
+
+		used, err = store.UseRecoveryCode(ctx, user.ID, "aaaaa-aaaaa")
+		require.NoError(t, err)
+		assert.False(t, used)
+
+		used, err = store.UseRecoveryCode(ctx, user.ID, "unknown")
+		require.NoError(t, err)
+		assert.False(t, used)
+
+		count, err = store.CountRecoveryCodes(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	require.NoError(t, store.DeleteTOTP(ctx, user.ID))
+
+	enrollment, err = store.GetTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, enrollment)
+
+	count, err := store.CountRecoveryCodes(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
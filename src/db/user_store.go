@@ -2,21 +2,67 @@ package db
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/base64"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+var (
+	ErrDuplicateEmail    = errors.New("a user with this email already exists")
+	ErrDuplicateUsername = errors.New("a user with this username already exists")
+)
+
+// uniqueViolationCode is the Postgres error code for a UNIQUE clash.
+const uniqueViolationCode = "23505"
+
+// userConflict translates unique violations on the users table into
+// ErrDuplicateEmail or ErrDuplicateUsername and returns other errors as is.
+func userConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolationCode {
+		return err
+	}
+
+	switch pgErr.ConstraintName {
+	case "users_email_lower_key":
+		return ErrDuplicateEmail
+	case "users_username_lower_key":
+		return ErrDuplicateUsername
+	}
+	return err
+}
+
+// Roles a user can have. Admins can do everything a user can.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
 )
 
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           int        `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"-"`
+	Activated    bool       `json:"activated"`
+	Role         string     `json:"role"`
+	LockedAt     *time.Time `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func (u *User) HasRole(role string) bool {
+	return u.Role == role || u.Role == RoleAdmin
+}
+
+// Locked reports whether an admin has locked the account, which keeps the
+// user from signing in.
+func (u *User) Locked() bool {
+	return u.LockedAt != nil
 }
 
 type PostgresUserStore struct {
@@ -30,24 +76,32 @@ func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
 type UserStore interface {
 	RegisterUser(ctx context.Context, user *User) (*User, error)
 	DeleteUser(ctx context.Context, id int64) error
-	UpdateUser(ctx context.Context, user *User) error
+	SoftDeleteUser(ctx context.Context, id int64, restoreToken *tokens.Token) error
+	RestoreUser(ctx context.Context, token string) (*User, error)
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (int64, error)
+	UpdateUser(ctx context.Context, user *User, activation *tokens.Token) error
+	UpdatePasswordHash(ctx context.Context, userID int, oldHash, newHash string) error
+	ResetPassword(ctx context.Context, token, passwordHash string) error
 	GetUserByID(ctx context.Context, id int64) (*User, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByIdentifier(ctx context.Context, identifier string) (*User, error)
 	CheckEmailExists(ctx context.Context, email string) (bool, error)
+	CheckUsernameExists(ctx context.Context, username string) (bool, error)
 	GetUserByToken(ctx context.Context, token string, scope string) (*User, error)
 }
 
 func (pg *PostgresUserStore) RegisterUser(ctx context.Context, user *User) (*User, error) {
 	query := `
-	INSERT INTO users (username, email, password_hash)
-	VALUES ($1, $2, $3)
-	returning id, created_at, updated_at
+	INSERT INTO users (username, email, password_hash, activated)
+	VALUES ($1, $2, $3, $4)
+	returning id, role, created_at, updated_at
 	`
 
-	err := pg.db.QueryRowContext(ctx, query, user.Username, user.Email, user.PasswordHash).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := pg.db.QueryRowContext(ctx, query, user.Username, user.Email, user.PasswordHash, user.Activated).Scan(&user.ID, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
-		return nil, err
+		return nil, userConflict(err)
 	}
 
 	return user, nil
@@ -76,7 +130,128 @@ func (pg *PostgresUserStore) DeleteUser(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (pg *PostgresUserStore) UpdateUser(ctx context.Context, user *User) error {
+// SoftDeleteUser marks the user deleted and signs them out everywhere. Their
+// tasks are kept so the account can be restored with restoreToken until
+// PurgeDeletedUsers removes it for good.
+func (pg *PostgresUserStore) SoftDeleteUser(ctx context.Context, id int64, restoreToken *tokens.Token) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	UPDATE users
+	SET deleted_at = CURRENT_TIMESTAMP
+	WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no user with id %d: %w", id, sql.ErrNoRows)
+	}
+
+	_, err = deleteUserCredentials(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+	`
+
+	err = tx.QueryRowContext(ctx, query, restoreToken.Hash, id, restoreToken.Expiry, restoreToken.Scope).Scan(&restoreToken.ID, &restoreToken.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RestoreUser undoes a soft delete for the holder of an unexpired restore
+// token. It returns nil if the token is unknown or expired.
+func (pg *PostgresUserStore) RestoreUser(ctx context.Context, token string) (*User, error) {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	user := &User{}
+
+	query := `
+	UPDATE users u
+	SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+	FROM tokens t
+	WHERE t.user_id = u.id AND t.hash = $1 AND t.scope = $2
+		AND t.expiry > CURRENT_TIMESTAMP AND u.deleted_at IS NOT NULL
+	RETURNING u.id, u.username, u.email, u.password_hash, u.activated, u.role, u.locked_at, u.created_at, u.updated_at
+	`
+
+	err = tx.QueryRowContext(ctx, query, tokens.HashToken(token), tokens.ScopeRestore).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.Activated,
+		&user.Role,
+		&user.LockedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, user.ID, tokens.ScopeRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+// PurgeDeletedUsers permanently removes up to limit users deleted before
+// deletedBefore, along with everything that cascades from them, and reports
+// how many it removed.
+func (pg *PostgresUserStore) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (int64, error) {
+	query := `
+	DELETE FROM users
+	WHERE id IN (
+		SELECT id FROM users
+		WHERE deleted_at < $1
+		LIMIT $2
+	)
+	`
+
+	result, err := pg.db.ExecContext(ctx, query, deletedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// UpdateUser saves the user's profile. If activation is set, it replaces the
+// user's earlier activation tokens in the same transaction, so a token sent to
+// an old email address cannot activate a new one.
+func (pg *PostgresUserStore) UpdateUser(ctx context.Context, user *User, activation *tokens.Token) error {
 	if user == nil {
 		return errors.New("cannot update nil user")
 	}
@@ -90,19 +265,20 @@ func (pg *PostgresUserStore) UpdateUser(ctx context.Context, user *User) error {
 
 	query := `
 	UPDATE users
-	SET username = $1, email = $2, password_hash = $3, updated_at = CURRENT_TIMESTAMP
-	WHERE id = $4
+	SET username = $1, email = $2, password_hash = $3, activated = $4, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $5
 	`
 
 	result, err := transaction.ExecContext(ctx, query,
 		user.Username,
 		user.Email,
 		user.PasswordHash,
+		user.Activated,
 		user.ID,
 	)
 
 	if err != nil {
-		return err
+		return userConflict(err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -114,6 +290,28 @@ func (pg *PostgresUserStore) UpdateUser(ctx context.Context, user *User) error {
 		return sql.ErrNoRows
 	}
 
+	if activation != nil {
+		query = `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope = $2
+		`
+
+		_, err = transaction.ExecContext(ctx, query, user.ID, tokens.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		query = `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)
+		`
+
+		_, err = transaction.ExecContext(ctx, query, activation.Hash, activation.UserID, activation.Expiry, activation.Scope)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = transaction.Commit()
 	if err != nil {
 		return err
@@ -122,19 +320,96 @@ func (pg *PostgresUserStore) UpdateUser(ctx context.Context, user *User) error {
 	return nil
 }
 
+// UpdatePasswordHash swaps a user's hash for a fresh one of the same password,
+// as when the hashing parameters have changed. It does nothing if the hash is
+// no longer oldHash, so a password changed in the meantime is never undone.
+func (pg *PostgresUserStore) UpdatePasswordHash(ctx context.Context, userID int, oldHash, newHash string) error {
+	query := `
+	UPDATE users
+	SET password_hash = $1
+	WHERE id = $2 AND password_hash = $3
+	`
+
+	_, err := pg.db.ExecContext(ctx, query, newHash, userID, oldHash)
+	return err
+}
+
+// ResetPassword uses up a password reset token to set its user's password and
+// signs them out everywhere. It returns sql.ErrNoRows if the token is unknown,
+// expired or already used, so of two requests racing with one token only one
+// succeeds.
+func (pg *PostgresUserStore) ResetPassword(ctx context.Context, token, passwordHash string) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > CURRENT_TIMESTAMP
+	RETURNING user_id
+	`
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, query, tokens.HashToken(token), tokens.ScopePasswordReset).Scan(&userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE users
+	SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $2 AND deleted_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	// Whoever knew the old password must not keep a session or a sign-in
+	// waiting for its second factor
+	query = `
+	DELETE FROM tokens
+	WHERE user_id = $1 AND scope IN ($2, $3, $4, $5)
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID, tokens.ScopePasswordReset, tokens.ScopeAuth, tokens.ScopeRefresh, tokens.ScopeTwoFactor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (pg *PostgresUserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
 	user := &User{}
 
 	query := `
-	SELECT id, username, email, created_at, updated_at
+	SELECT id, username, email, password_hash, activated, role, locked_at, created_at, updated_at
 	FROM users
-	WHERE id = $1
+	WHERE id = $1 AND deleted_at IS NULL
 	`
 
 	err := pg.db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
+		&user.PasswordHash,
+		&user.Activated,
+		&user.Role,
+		&user.LockedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -154,9 +429,9 @@ func (pg *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (
 	user := &User{}
 
 	query := `
-	SELECT id, username, email, password_hash, created_at, updated_at
+	SELECT id, username, email, password_hash, activated, role, locked_at, created_at, updated_at
 	FROM users
-	WHERE email = $1
+	WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL
 	`
 
 	err := pg.db.QueryRowContext(ctx, query, email).Scan(
@@ -164,6 +439,41 @@ func (pg *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.Activated,
+		&user.Role,
+		&user.LockedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, sql.ErrNoRows
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (pg *PostgresUserStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	user := &User{}
+
+	query := `
+	SELECT id, username, email, password_hash, activated, role, locked_at, created_at, updated_at
+	FROM users
+	WHERE LOWER(username) = LOWER($1) AND deleted_at IS NULL
+	`
+
+	err := pg.db.QueryRowContext(ctx, query, username).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.Activated,
+		&user.Role,
+		&user.LockedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -179,8 +489,18 @@ func (pg *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (
 	return user, nil
 }
 
+// GetUserByIdentifier looks a user up by username or email, as typed at
+// login. Usernames cannot contain an @, so anything with one is an email.
+func (pg *PostgresUserStore) GetUserByIdentifier(ctx context.Context, identifier string) (*User, error) {
+	identifier = strings.TrimSpace(identifier)
+	if strings.Contains(identifier, "@") {
+		return pg.GetUserByEmail(ctx, identifier)
+	}
+	return pg.GetUserByUsername(ctx, identifier)
+}
+
 func (pg *PostgresUserStore) CheckEmailExists(ctx context.Context, email string) (bool, error) {
-	query := "SELECT 1 from users WHERE email = $1 LIMIT 1"
+	query := "SELECT 1 from users WHERE LOWER(email) = LOWER($1) LIMIT 1"
 
 	var exists int
 	err := pg.db.QueryRowContext(ctx, query, email).Scan(&exists)
@@ -194,17 +514,31 @@ func (pg *PostgresUserStore) CheckEmailExists(ctx context.Context, email string)
 	return true, nil
 }
 
+func (pg *PostgresUserStore) CheckUsernameExists(ctx context.Context, username string) (bool, error) {
+	query := "SELECT 1 from users WHERE LOWER(username) = LOWER($1) LIMIT 1"
+
+	var exists int
+	err := pg.db.QueryRowContext(ctx, query, username).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (pg *PostgresUserStore) GetUserByToken(ctx context.Context, token string, scope string) (*User, error) {
 
-	hash := sha256.Sum256([]byte(token))
-	hashedToken := base64.URLEncoding.EncodeToString(hash[:])
+	hashedToken := tokens.HashToken(token)
 
 	var user User
 	query := `
-			SELECT u.id, u.username, u.email, u.password_hash, u.created_at, u.updated_at
+			SELECT u.id, u.username, u.email, u.password_hash, u.activated, u.role, u.locked_at, u.created_at, u.updated_at
 			FROM users u
 			INNER JOIN tokens t ON u.id = t.user_id
-			WHERE t.token = $1 AND t.scope = $2 AND t.expiry > CURRENT_TIMESTAMP
+			WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > CURRENT_TIMESTAMP AND u.deleted_at IS NULL
 			LIMIT 1;
 	`
 	err := pg.db.QueryRowContext(ctx, query, hashedToken, scope).Scan(
@@ -212,6 +546,9 @@ func (pg *PostgresUserStore) GetUserByToken(ctx context.Context, token string, s
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.Activated,
+		&user.Role,
+		&user.LockedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -2,10 +2,13 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/tokens"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -79,3 +82,271 @@ func validUser(username string, email string) *User {
 		PasswordHash: string(hashedPassword),
 	}
 }
+
+func TestActivateUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresUserStore(db)
+	ctx := context.Background()
+
+	user, err := store.RegisterUser(ctx, validUser("inactive", "inactive@example.com"))
+	require.NoError(t, err)
+
+	fetched, err := store.GetUserByEmail(ctx, "inactive@example.com")
+	require.NoError(t, err)
+	assert.False(t, fetched.Activated, "new users start inactive")
+
+	fetched.Activated = true
+	err = store.UpdateUser(ctx, fetched, nil)
+	require.NoError(t, err)
+
+	fetched, err = store.GetUserByID(ctx, int64(user.ID))
+	require.NoError(t, err)
+	assert.True(t, fetched.Activated)
+
+	t.Run("New email", func(t *testing.T) {
+		tokenStore := NewPostgresTokenStore(db)
+		old, err := tokenStore.GenerateToken(ctx, int64(user.ID), tokens.ActivationTTL, tokens.ScopeActivation)
+		require.NoError(t, err)
+
+		activation, err := tokens.GenerateToken(user.ID, tokens.ActivationTTL, tokens.ScopeActivation)
+		require.NoError(t, err)
+
+		fetched.Email = "moved@example.com"
+		fetched.Activated = false
+		require.NoError(t, store.UpdateUser(ctx, fetched, activation))
+
+		activated, err := store.GetUserByToken(ctx, activation.Plaintext, tokens.ScopeActivation)
+		require.NoError(t, err)
+		require.NotNil(t, activated)
+		assert.False(t, activated.Activated)
+
+		activated, err = store.GetUserByToken(ctx, old.Plaintext, tokens.ScopeActivation)
+		require.NoError(t, err)
+		assert.Nil(t, activated, "tokens sent to the old address are revoked")
+	})
+}
+
+func TestUpdatePasswordHash(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresUserStore(db)
+	ctx := context.Background()
+
+	user, err := store.RegisterUser(ctx, validUser("rehashed", "rehashed@example.com"))
+	require.NoError(t, err)
+	legacyHash := user.PasswordHash
+
+	err = store.UpdatePasswordHash(ctx, user.ID, legacyHash, "$argon2id$first")
+	require.NoError(t, err)
+
+	fetched, err := store.GetUserByID(ctx, int64(user.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "$argon2id$first", fetched.PasswordHash)
+
+	// A stale old hash leaves the current one alone
+	err = store.UpdatePasswordHash(ctx, user.ID, legacyHash, "$argon2id$second")
+	require.NoError(t, err)
+
+	fetched, err = store.GetUserByID(ctx, int64(user.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "$argon2id$first", fetched.PasswordHash)
+}
+
+func TestResetPassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresUserStore(db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	user, err := store.RegisterUser(ctx, validUser("forgetful", "forgetful@example.com"))
+	require.NoError(t, err)
+
+	session, err := tokenStore.GenerateToken(ctx, int64(user.ID), tokens.AuthTTL, tokens.ScopeAuth)
+	require.NoError(t, err)
+	refresh, err := tokenStore.GenerateToken(ctx, int64(user.ID), tokens.RefreshTTL, tokens.ScopeRefresh)
+	require.NoError(t, err)
+	activation, err := tokenStore.GenerateToken(ctx, int64(user.ID), tokens.ActivationTTL, tokens.ScopeActivation)
+	require.NoError(t, err)
+	reset, err := tokenStore.GenerateToken(ctx, int64(user.ID), tokens.PasswordResetTTL, tokens.ScopePasswordReset)
+	require.NoError(t, err)
+	otherReset, err := tokenStore.GenerateToken(ctx, int64(user.ID), tokens.PasswordResetTTL, tokens.ScopePasswordReset)
+	require.NoError(t, err)
+
+	require.NoError(t, store.ResetPassword(ctx, reset.Plaintext, "$argon2id$reset"))
+
+	fetched, err := store.GetUserByID(ctx, int64(user.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "$argon2id$reset", fetched.PasswordHash)
+
+	t.Run("Signs the user out", func(t *testing.T) {
+		for _, token := range []*tokens.Token{session, refresh, otherReset} {
+			found, err := store.GetUserByToken(ctx, token.Plaintext, token.Scope)
+			require.NoError(t, err)
+			assert.Nil(t, found, token.Scope)
+		}
+
+		found, err := store.GetUserByToken(ctx, activation.Plaintext, tokens.ScopeActivation)
+		require.NoError(t, err)
+		assert.NotNil(t, found, "other kinds of token are kept")
+	})
+
+	t.Run("Single use", func(t *testing.T) {
+		err := store.ResetPassword(ctx, reset.Plaintext, "$argon2id$again")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		fetched, err := store.GetUserByID(ctx, int64(user.ID))
+		require.NoError(t, err)
+		assert.Equal(t, "$argon2id$reset", fetched.PasswordHash)
+	})
+
+	t.Run("Expired or wrong scope", func(t *testing.T) {
+		expired, err := tokenStore.GenerateToken(ctx, int64(user.ID), -time.Minute, tokens.ScopePasswordReset)
+		require.NoError(t, err)
+		assert.ErrorIs(t, store.ResetPassword(ctx, expired.Plaintext, "$argon2id$late"), sql.ErrNoRows)
+
+		assert.ErrorIs(t, store.ResetPassword(ctx, activation.Plaintext, "$argon2id$wrong"), sql.ErrNoRows)
+	})
+}
+
+func TestUserConflicts(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresUserStore(db)
+	ctx := context.Background()
+
+	_, err := store.RegisterUser(ctx, validUser("taken", "taken@example.com"))
+	require.NoError(t, err)
+
+	_, err = store.RegisterUser(ctx, validUser("taken", "other@example.com"))
+	assert.ErrorIs(t, err, ErrDuplicateUsername)
+
+	_, err = store.RegisterUser(ctx, validUser("other", "taken@example.com"))
+	assert.ErrorIs(t, err, ErrDuplicateEmail)
+
+	user, err := store.RegisterUser(ctx, validUser("free", "free@example.com"))
+	require.NoError(t, err)
+
+	user.Email = "taken@example.com"
+	assert.ErrorIs(t, store.UpdateUser(ctx, user, nil), ErrDuplicateEmail)
+
+	user.Email = "free@example.com"
+	user.Username = "taken"
+	assert.ErrorIs(t, store.UpdateUser(ctx, user, nil), ErrDuplicateUsername)
+
+	t.Run("Ignores case", func(t *testing.T) {
+		_, err := store.RegisterUser(ctx, validUser("TAKEN", "new@example.com"))
+		assert.ErrorIs(t, err, ErrDuplicateUsername)
+
+		_, err = store.RegisterUser(ctx, validUser("new", "Taken@Example.com"))
+		assert.ErrorIs(t, err, ErrDuplicateEmail)
+
+		exists, err := store.CheckUsernameExists(ctx, "Taken")
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = store.CheckEmailExists(ctx, "TAKEN@example.com")
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		user.Username = "Free"
+		user.Email = "FREE@example.com"
+		assert.NoError(t, store.UpdateUser(ctx, user, nil), "a user can change the case of their own username and email")
+	})
+}
+
+func TestGetUserByIdentifier(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresUserStore(db)
+	ctx := context.Background()
+
+	user, err := store.RegisterUser(ctx, validUser("Mover", "Mover@Example.com"))
+	require.NoError(t, err)
+
+	for _, identifier := range []string{"Mover", "mover", " MOVER ", "mover@example.com", "Mover@Example.com"} {
+		fetched, err := store.GetUserByIdentifier(ctx, identifier)
+		require.NoError(t, err, identifier)
+		assert.Equal(t, user.ID, fetched.ID, identifier)
+		assert.Equal(t, "Mover", fetched.Username, "the username is kept as registered")
+	}
+
+	_, err = store.GetUserByIdentifier(ctx, "nobody")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = store.GetUserByIdentifier(ctx, "mover@example.org")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestSoftDeleteUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresUserStore(db)
+	tokenStore := NewPostgresTokenStore(db)
+	ctx := context.Background()
+
+	user, err := store.RegisterUser(ctx, validUser("leaving", "leaving@example.com"))
+	require.NoError(t, err)
+
+	session, err := tokenStore.GenerateToken(ctx, int64(user.ID), tokens.AuthTTL, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	restoreToken, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeRestore)
+	require.NoError(t, err)
+	require.NoError(t, store.SoftDeleteUser(ctx, int64(user.ID), restoreToken))
+
+	// Deleting twice is reported as a missing user
+	assert.ErrorIs(t, store.SoftDeleteUser(ctx, int64(user.ID), restoreToken), sql.ErrNoRows)
+
+	_, err = store.GetUserByEmail(ctx, "leaving@example.com")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	signedIn, err := store.GetUserByToken(ctx, session.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Nil(t, signedIn, "sessions are revoked")
+
+	t.Run("Unknown restore token", func(t *testing.T) {
+		restored, err := store.RestoreUser(ctx, "unknown")
+		require.NoError(t, err)
+		assert.Nil(t, restored)
+	})
+
+	t.Run("Grace period not over", func(t *testing.T) {
+		purged, err := store.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour), 10)
+		require.NoError(t, err)
+		assert.Zero(t, purged)
+	})
+
+	restored, err := store.RestoreUser(ctx, restoreToken.Plaintext)
+	require.NoError(t, err)
+	require.NotNil(t, restored)
+	assert.Equal(t, user.ID, restored.ID)
+
+	_, err = store.GetUserByEmail(ctx, "leaving@example.com")
+	require.NoError(t, err)
+
+	restored, err = store.RestoreUser(ctx, restoreToken.Plaintext)
+	require.NoError(t, err)
+	assert.Nil(t, restored, "restore tokens are single use")
+
+	t.Run("Purge after the grace period", func(t *testing.T) {
+		restoreToken, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeRestore)
+		require.NoError(t, err)
+		require.NoError(t, store.SoftDeleteUser(ctx, int64(user.ID), restoreToken))
+
+		purged, err := store.PurgeDeletedUsers(ctx, time.Now().Add(time.Minute), 10)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+
+		restored, err := store.RestoreUser(ctx, restoreToken.Plaintext)
+		require.NoError(t, err)
+		assert.Nil(t, restored)
+	})
+}
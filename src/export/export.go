@@ -0,0 +1,193 @@
+// Package export writes a user's personal data as a ZIP archive of JSON
+// files. The layout is versioned by SchemaVersion, which changes whenever a
+// field is removed or changes meaning; new fields may be added within a
+// version.
+//
+// Version 1 archives contain:
+//
+//	manifest.json  Manifest
+//	profile.json   Profile
+//	tasks.json     array of Task, oldest first
+//	sessions.json  array of Session
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+const SchemaVersion = 1
+
+// Format identifies the archive in its manifest.
+const Format = "moving-checklist-export"
+
+const (
+	ManifestFile = "manifest.json"
+	ProfileFile  = "profile.json"
+	TasksFile    = "tasks.json"
+	SessionsFile = "sessions.json"
+)
+
+type Manifest struct {
+	Format        string    `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	Files         []string  `json:"files"`
+}
+
+type Profile struct {
+	ID               int       `json:"id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	Activated        bool      `json:"activated"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Task times are null when not set.
+type Task struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Category    string     `json:"category"`
+	IsComplete  bool       `json:"is_complete"`
+	DueDate     *time.Time `json:"due_date"`
+	DueOn       *string    `json:"due_on"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+// Session describes a signed-in device. Token values are never exported.
+type Session struct {
+	ID         int64      `json:"id"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+}
+
+// Archive writes files to a ZIP stream one after another, so only the entry
+// being written is held in memory.
+type Archive struct {
+	zw    *zip.Writer
+	files []string
+	open  *ArrayWriter
+}
+
+func NewArchive(w io.Writer) *Archive {
+	return &Archive{zw: zip.NewWriter(w)}
+}
+
+// WriteFile adds a file holding v as JSON.
+func (a *Archive) WriteFile(name string, v any) error {
+	w, err := a.create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// CreateArray adds a file holding a JSON array whose elements are written one
+// at a time. The array must be closed before the next file is added.
+func (a *Archive) CreateArray(name string) (*ArrayWriter, error) {
+	w, err := a.create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.WriteString(w, "[")
+	if err != nil {
+		return nil, err
+	}
+
+	a.open = &ArrayWriter{w: w}
+	return a.open, nil
+}
+
+// Close writes the manifest listing every file added so far and finishes the
+// archive.
+func (a *Archive) Close(exportedAt time.Time) error {
+	err := a.WriteFile(ManifestFile, Manifest{
+		Format:        Format,
+		SchemaVersion: SchemaVersion,
+		ExportedAt:    exportedAt.UTC(),
+		Files:         a.files,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.zw.Close()
+}
+
+func (a *Archive) create(name string) (io.Writer, error) {
+	if a.open != nil && !a.open.closed {
+		return nil, errors.New("export: previous array was not closed")
+	}
+
+	w, err := a.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if name != ManifestFile {
+		a.files = append(a.files, name)
+	}
+	return w, nil
+}
+
+type ArrayWriter struct {
+	w      io.Writer
+	count  int
+	closed bool
+}
+
+// Write appends v to the array.
+func (aw *ArrayWriter) Write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	separator := "\n  "
+	if aw.count > 0 {
+		separator = ",\n  "
+	}
+
+	_, err = io.WriteString(aw.w, separator)
+	if err != nil {
+		return err
+	}
+
+	_, err = aw.w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	aw.count++
+	return nil
+}
+
+func (aw *ArrayWriter) Close() error {
+	end := "\n]\n"
+	if aw.count == 0 {
+		end = "]\n"
+	}
+
+	aw.closed = true
+	_, err := io.WriteString(aw.w, end)
+	return err
+}
@@ -0,0 +1,78 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, reader *zip.Reader, name string, v any) {
+	t.Helper()
+
+	file, err := reader.Open(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v), "%s is not valid JSON: %s", name, data)
+}
+
+func TestArchive(t *testing.T) {
+	var buf bytes.Buffer
+	exportedAt := time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC)
+	due := exportedAt.Add(48 * time.Hour)
+
+	archive := NewArchive(&buf)
+	require.NoError(t, archive.WriteFile(ProfileFile, Profile{ID: 7, Username: "mover"}))
+
+	tasks, err := archive.CreateArray(TasksFile)
+	require.NoError(t, err)
+	require.NoError(t, tasks.Write(Task{ID: 1, Name: "Book movers", DueDate: &due}))
+	require.NoError(t, tasks.Write(Task{ID: 2, Name: "Pack kitchen"}))
+
+	// The array has to be finished before moving on
+	err = archive.WriteFile(SessionsFile, []Session{})
+	require.Error(t, err)
+	require.NoError(t, tasks.Close())
+
+	sessions, err := archive.CreateArray(SessionsFile)
+	require.NoError(t, err)
+	require.NoError(t, sessions.Close())
+
+	require.NoError(t, archive.Close(exportedAt))
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	var manifest Manifest
+	readFile(t, reader, ManifestFile, &manifest)
+	assert.Equal(t, Manifest{
+		Format:        Format,
+		SchemaVersion: SchemaVersion,
+		ExportedAt:    exportedAt,
+		Files:         []string{ProfileFile, TasksFile, SessionsFile},
+	}, manifest)
+
+	var profile Profile
+	readFile(t, reader, ProfileFile, &profile)
+	assert.Equal(t, "mover", profile.Username)
+
+	var gotTasks []Task
+	readFile(t, reader, TasksFile, &gotTasks)
+	require.Len(t, gotTasks, 2)
+	assert.Equal(t, "Book movers", gotTasks[0].Name)
+	assert.True(t, due.Equal(*gotTasks[0].DueDate))
+	assert.Nil(t, gotTasks[1].DueDate)
+
+	var gotSessions []Session
+	readFile(t, reader, SessionsFile, &gotSessions)
+	assert.NotNil(t, gotSessions)
+	assert.Empty(t, gotSessions)
+}
@@ -0,0 +1,170 @@
+// Package lockout slows down credential guessing. Failures are counted per key
+// (an account, an IP address) and once a key reaches its threshold it is
+// locked out for a delay that doubles with every further failure.
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Attempts is the failure history the store keeps for a key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps failure counters. MemoryStore serves a single instance; a shared
+// store such as db.PostgresAttemptStore is needed when running several.
+type Store interface {
+	// Get returns the key's attempts, or zero Attempts if it has none that
+	// are still retained.
+	Get(ctx context.Context, key string) (Attempts, error)
+
+	// Increment records a failure at now and returns the count including it,
+	// in one atomic step. Counters whose last failure is older than retention
+	// start again from one.
+	Increment(ctx context.Context, key string, now time.Time, retention time.Duration) (Attempts, error)
+
+	// Decrement takes back one failure, e.g. an attempt counted before it was
+	// made that then succeeded.
+	Decrement(ctx context.Context, key string) error
+
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy configures how quickly a key is locked out. A zero Threshold turns
+// the policy off.
+type Policy struct {
+	// Threshold is the number of failures that triggers the first lockout.
+	Threshold int
+	// BaseDelay is the length of the first lockout; each later failure
+	// doubles it, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Delay returns how long a key with the given number of failures is locked
+// out after its last failure.
+func (p Policy) Delay(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+// Limiter applies a policy to one kind of key, e.g. accounts or IPs. Limiters
+// sharing a store must use distinct prefixes.
+type Limiter struct {
+	store  Store
+	prefix string
+	policy Policy
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, prefix string, policy Policy) *Limiter {
+	return &Limiter{
+		store:  store,
+		prefix: prefix,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// RetryAfter returns how much longer key is locked out, or zero if it may try
+// again now.
+func (l *Limiter) RetryAfter(ctx context.Context, key string) (time.Duration, error) {
+	if l.policy.Threshold <= 0 {
+		return 0, nil
+	}
+
+	attempts, err := l.store.Get(ctx, l.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.remaining(attempts), nil
+}
+
+// Fail records a failed attempt for key and returns the lockout it caused, if
+// any.
+func (l *Limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	if l.policy.Threshold <= 0 {
+		return 0, nil
+	}
+
+	// Keep counters at least as long as the longest lockout they can cause
+	retention := max(l.policy.Window, l.policy.MaxDelay)
+
+	attempts, err := l.store.Increment(ctx, l.prefix+key, l.now(), retention)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.remaining(attempts), nil
+}
+
+// Attempt counts an attempt for key as a failure before it is made, and
+// returns how long key must wait if it may not be made. Counting first means
+// concurrent attempts cannot all pass the check before any of them is counted:
+// of those racing past the threshold only the first is let through. An
+// attempt that turns out to succeed is taken back with Release or Reset.
+func (l *Limiter) Attempt(ctx context.Context, key string) (time.Duration, error) {
+	if l.policy.Threshold <= 0 {
+		return 0, nil
+	}
+
+	before, err := l.store.Get(ctx, l.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+
+	if wait := l.remaining(before); wait > 0 {
+		return wait, nil
+	}
+
+	retention := max(l.policy.Window, l.policy.MaxDelay)
+
+	attempts, err := l.store.Increment(ctx, l.prefix+key, l.now(), retention)
+	if err != nil {
+		return 0, err
+	}
+
+	// Failures counted between the Get and the Increment were made just
+	// now, so if they reached the threshold the key is locked out already
+	if attempts.Failures > before.Failures+1 && attempts.Failures > l.policy.Threshold {
+		return l.policy.Delay(attempts.Failures - 1), nil
+	}
+
+	return 0, nil
+}
+
+// Release takes back an attempt counted by Attempt that succeeded, leaving
+// earlier failures in place.
+func (l *Limiter) Release(ctx context.Context, key string) error {
+	if l.policy.Threshold <= 0 {
+		return nil
+	}
+	return l.store.Decrement(ctx, l.prefix+key)
+}
+
+// Reset clears key's failures, e.g. after a successful login.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	if l.policy.Threshold <= 0 {
+		return nil
+	}
+	return l.store.Reset(ctx, l.prefix+key)
+}
+
+func (l *Limiter) remaining(attempts Attempts) time.Duration {
+	lockedUntil := attempts.LastFailure.Add(l.policy.Delay(attempts.Failures))
+	return max(lockedUntil.Sub(l.now()), 0)
+}
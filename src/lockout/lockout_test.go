@@ -0,0 +1,160 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = Policy{
+	Threshold: 3,
+	BaseDelay: time.Minute,
+	MaxDelay:  10 * time.Minute,
+	Window:    15 * time.Minute,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Minute},
+		{failures: 4, want: 2 * time.Minute},
+		{failures: 5, want: 4 * time.Minute},
+		{failures: 6, want: 8 * time.Minute},
+		{failures: 7, want: 10 * time.Minute},
+		{failures: 100, want: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, testPolicy.Delay(tt.failures), "%d failures", tt.failures)
+	}
+
+	assert.Zero(t, Policy{}.Delay(100))
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	limiter := NewLimiter(NewMemoryStore(), "account:", testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	for range 2 {
+		retryAfter, err := limiter.Fail(ctx, "example@example.com")
+		require.NoError(t, err)
+		assert.Zero(t, retryAfter)
+	}
+
+	retryAfter, err := limiter.Fail(ctx, "example@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, retryAfter)
+
+	// Other keys are unaffected
+	retryAfter, err = limiter.RetryAfter(ctx, "other@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	now = now.Add(30 * time.Second)
+	retryAfter, err = limiter.RetryAfter(ctx, "example@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	// Failing again once the lockout ends doubles it
+	now = now.Add(time.Minute)
+	retryAfter, err = limiter.Fail(ctx, "example@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, retryAfter)
+
+	require.NoError(t, limiter.Reset(ctx, "example@example.com"))
+	retryAfter, err = limiter.RetryAfter(ctx, "example@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
+
+func TestLimiterForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	limiter := NewLimiter(NewMemoryStore(), "ip:", testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	for range 2 {
+		_, err := limiter.Fail(ctx, "203.0.113.1")
+		require.NoError(t, err)
+	}
+
+	now = now.Add(testPolicy.Window + time.Second)
+	retryAfter, err := limiter.Fail(ctx, "203.0.113.1")
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), "ip:", Policy{})
+
+	for range 10 {
+		retryAfter, err := limiter.Fail(ctx, "203.0.113.1")
+		require.NoError(t, err)
+		assert.Zero(t, retryAfter)
+	}
+}
+
+func TestLimiterAttempt(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	limiter := NewLimiter(NewMemoryStore(), "account:", testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	for range 3 {
+		retryAfter, err := limiter.Attempt(ctx, "example@example.com")
+		require.NoError(t, err)
+		assert.Zero(t, retryAfter)
+	}
+
+	retryAfter, err := limiter.Attempt(ctx, "example@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, retryAfter)
+
+	// Once the lockout is over one more attempt may be made
+	now = now.Add(time.Minute)
+	retryAfter, err = limiter.Attempt(ctx, "example@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	// Taking back a successful attempt leaves the earlier failures
+	require.NoError(t, limiter.Release(ctx, "example@example.com"))
+	retryAfter, err = limiter.RetryAfter(ctx, "example@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, retryAfter)
+}
+
+func TestLimiterAttemptConcurrent(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), "account:", testPolicy)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retryAfter, err := limiter.Attempt(ctx, "example@example.com")
+			if err == nil && retryAfter == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(testPolicy.Threshold), allowed.Load(), "attempts racing past the threshold must not all get through")
+}
@@ -0,0 +1,83 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many increments pass between removals of expired
+// counters, which keeps memory bounded under a spray of distinct keys.
+const sweepEvery = 1024
+
+type memoryEntry struct {
+	attempts Attempts
+	expires  time.Time
+}
+
+// MemoryStore keeps counters in process memory.
+type MemoryStore struct {
+	mu         sync.Mutex
+	entries    map[string]*memoryEntry
+	increments int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (ms *MemoryStore) Get(ctx context.Context, key string) (Attempts, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry, ok := ms.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return Attempts{}, nil
+	}
+	return entry.attempts, nil
+}
+
+func (ms *MemoryStore) Increment(ctx context.Context, key string, now time.Time, retention time.Duration) (Attempts, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.increments++
+	if ms.increments%sweepEvery == 0 {
+		for k, entry := range ms.entries {
+			if now.After(entry.expires) {
+				delete(ms.entries, k)
+			}
+		}
+	}
+
+	entry, ok := ms.entries[key]
+	if !ok || now.After(entry.expires) {
+		entry = &memoryEntry{}
+		ms.entries[key] = entry
+	}
+
+	entry.attempts.Failures++
+	entry.attempts.LastFailure = now
+	entry.expires = now.Add(retention)
+
+	return entry.attempts, nil
+}
+
+func (ms *MemoryStore) Decrement(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry, ok := ms.entries[key]
+	if ok && entry.attempts.Failures > 0 {
+		entry.attempts.Failures--
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Reset(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.entries, key)
+	return nil
+}
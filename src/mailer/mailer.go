@@ -0,0 +1,98 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email such as activation links.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   string
+}
+
+func NewSMTPMailer(host string, port int, username, password, sender string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		sender:   sender,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	headers := []string{
+		"From: " + m.sender,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n" + msg.Body
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	err := smtp.SendMail(addr, auth, m.sender, []string{msg.To}, []byte(body))
+	if err != nil {
+		return fmt.Errorf("mailer: smtp send: %w", err)
+	}
+
+	return nil
+}
+
+// OutboxMailer records messages in the outbox_emails table and logs them
+// instead of delivering them, so flows that need email work locally.
+type OutboxMailer struct {
+	outboxStore db.OutboxStore
+	logger      *log.Logger
+}
+
+func NewOutboxMailer(outboxStore db.OutboxStore, logger *log.Logger) *OutboxMailer {
+	return &OutboxMailer{
+		outboxStore: outboxStore,
+		logger:      logger,
+	}
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg Message) error {
+	email := &db.OutboxEmail{
+		Recipient: msg.To,
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+	}
+
+	err := m.outboxStore.InsertEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("mailer: outbox insert: %w", err)
+	}
+
+	m.logger.Printf("Mailer: queued email %d to %s - %q\n%s", email.ID, msg.To, msg.Subject, msg.Body)
+	return nil
+}
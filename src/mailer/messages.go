@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+)
+
+func ActivationMessage(to, username, token string) Message {
+	return Message{
+		To:      to,
+		Subject: "Activate your Moving Checklist account",
+		Body: fmt.Sprintf(`Hi %s,
+
+Thanks for signing up for Moving Checklist. To activate your account, send
+the following token to PUT /users/activated:
+
+{"token": "%s"}
+
+The token expires in 3 days.
+`, username, token),
+	}
+}
+
+func PasswordResetMessage(to, username, token string) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your Moving Checklist password",
+		Body: fmt.Sprintf(`Hi %s,
+
+Someone asked to reset the password for your Moving Checklist account. If it
+was you, send the following token along with your new password to
+PUT /users/password:
+
+{"token": "%s", "password": "<your new password>"}
+
+The token expires in 45 minutes and can only be used once. If you did not ask
+for a reset you can ignore this email.
+`, username, token),
+	}
+}
+
+func AccountDeletedMessage(to, username, token string, restoreBy time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "Your Moving Checklist account was deleted",
+		Body: fmt.Sprintf(`Hi %s,
+
+Your Moving Checklist account has been deleted and signed out everywhere. If
+this was a mistake you can restore it, along with all of your tasks, by
+sending the following token to POST /users/restore:
+
+{"token": "%s"}
+
+The account and its tasks are permanently removed after %s.
+`, username, token, restoreBy.UTC().Format("January 2, 2006 15:04 MST")),
+	}
+}
@@ -1,26 +1,63 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	// Time zones chosen in user preferences must load on hosts without a
+	// time zone database
+	_ "time/tzdata"
+
 	"github.com/trevortippery/moving-checklist/app"
 	"github.com/trevortippery/moving-checklist/routes"
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish once the
+// server is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	var port int
 	flag.IntVar(&port, "port", 8080, "go backend server port")
 	flag.Parse()
 
-	app, err := app.NewApplication()
+	cfg := app.LoadConfig()
+
+	app, err := app.NewApplication(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	defer app.DB.Close()
+	runErr := run(app, port)
+	if runErr != nil {
+		app.Logger.Printf("Error in main: %v", runErr)
+	}
+
+	err = app.Close()
+	if err != nil {
+		app.Logger.Printf("Error in main: Closing application - %v", err)
+	}
+
+	app.Logger.Println("Server stopped")
+
+	// A failure exits non-zero, but only after everything is closed
+	if runErr != nil {
+		os.Exit(1)
+	}
+}
+
+// run serves requests until the server fails or SIGINT or SIGTERM arrives,
+// then waits for in-flight requests to finish.
+func run(app *app.Application, port int) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	routes := routes.SetupRoutes(app)
 
@@ -32,10 +69,33 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	app.Logger.Printf("Server is running on port: %d\n", port)
+	serveErr := make(chan error, 1)
+	go func() {
+		app.Logger.Printf("Server is running on port: %d\n", port)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
 
-	err = server.ListenAndServe()
+	// A second signal kills the process straight away
+	stop()
+	app.Logger.Println("Shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
 	if err != nil {
-		app.Logger.Fatal(err)
+		return err
+	}
+
+	err = <-serveErr
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
+	return err
 }
@@ -0,0 +1,172 @@
+// Package maintenance runs periodic cleanup jobs inside the server process.
+package maintenance
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/db"
+)
+
+// Job is a cleanup task run every Interval. Run reports how many rows it
+// removed.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) (int64, error)
+}
+
+// Stats describe a job's runs since the server started. Runs skipped because
+// another instance held the lock are not counted.
+type Stats struct {
+	Name         string     `json:"name"`
+	Runs         int64      `json:"runs"`
+	LastRunAt    *time.Time `json:"last_run_at"`
+	LastRemoved  int64      `json:"last_removed"`
+	TotalRemoved int64      `json:"total_removed"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// Runner runs each job once at start and then on its interval. Every run
+// holds an advisory lock named after the job, so with several instances
+// sharing a database only one of them cleans up at a time.
+type Runner struct {
+	locker db.AdvisoryLocker
+	logger *log.Logger
+	jobs   []Job
+
+	mu    sync.Mutex
+	stats map[string]*Stats
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func NewRunner(locker db.AdvisoryLocker, logger *log.Logger) *Runner {
+	return &Runner{
+		locker: locker,
+		logger: logger,
+		stats:  make(map[string]*Stats),
+	}
+}
+
+// Add registers a job. Jobs must be added before Start.
+func (r *Runner) Add(job Job) {
+	r.jobs = append(r.jobs, job)
+	r.stats[job.Name] = &Stats{Name: job.Name}
+}
+
+func (r *Runner) Start() {
+	ctx, stop := context.WithCancel(context.Background())
+	r.stop = stop
+
+	for _, job := range r.jobs {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.loop(ctx, job)
+		}()
+	}
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (r *Runner) Stop() {
+	if r.stop == nil {
+		return
+	}
+	r.stop()
+	r.wg.Wait()
+}
+
+// Stats returns a snapshot of every job's stats in the order they were added.
+func (r *Runner) Stats() []Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make([]Stats, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		stats = append(stats, *r.stats[job.Name])
+	}
+	return stats
+}
+
+func (r *Runner) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.runOnce(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce runs job under its lock and records the outcome. It returns false
+// if another instance was already running it.
+func (r *Runner) runOnce(ctx context.Context, job Job) bool {
+	var removed int64
+	locked, err := r.locker.TryLock(ctx, job.Name, func(ctx context.Context) error {
+		var err error
+		removed, err = job.Run(ctx)
+		return err
+	})
+
+	// Shutting down is not worth reporting
+	if ctx.Err() != nil {
+		return locked
+	}
+
+	if err != nil {
+		r.logger.Printf("Error in maintenance job %s: %v", job.Name, err)
+	}
+	if removed > 0 {
+		r.logger.Printf("Maintenance job %s removed %d rows", job.Name, removed)
+	}
+
+	if !locked && err == nil {
+		return false
+	}
+
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats[job.Name]
+	stats.Runs++
+	stats.LastRunAt = &now
+	stats.LastRemoved = removed
+	stats.TotalRemoved += removed
+	stats.LastError = ""
+	if err != nil {
+		stats.LastError = err.Error()
+	}
+
+	return locked
+}
+
+// Batched repeats deleteBatch, which removes up to size rows, until a batch
+// comes back short, and reports the total removed.
+func Batched(size int, deleteBatch func(ctx context.Context, limit int) (int64, error)) func(ctx context.Context) (int64, error) {
+	return func(ctx context.Context) (int64, error) {
+		var total int64
+		for {
+			removed, err := deleteBatch(ctx, size)
+			total += removed
+			if err != nil || removed < int64(size) {
+				return total, err
+			}
+
+			err = ctx.Err()
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+}
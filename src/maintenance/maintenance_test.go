@@ -0,0 +1,155 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLocker hands out each lock to one holder at a time, like an advisory
+// lock shared between instances.
+type fakeLocker struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func (fl *fakeLocker) TryLock(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error) {
+	fl.mu.Lock()
+	if fl.held[name] {
+		fl.mu.Unlock()
+		return false, nil
+	}
+	fl.held[name] = true
+	fl.mu.Unlock()
+
+	defer func() {
+		fl.mu.Lock()
+		delete(fl.held, name)
+		fl.mu.Unlock()
+	}()
+
+	return true, fn(ctx)
+}
+
+func newTestRunner() *Runner {
+	return NewRunner(&fakeLocker{held: map[string]bool{}}, log.New(io.Discard, "", 0))
+}
+
+func TestBatched(t *testing.T) {
+	remaining := int64(25)
+	var limits []int
+
+	run := Batched(10, func(ctx context.Context, limit int) (int64, error) {
+		limits = append(limits, limit)
+		removed := min(remaining, int64(limit))
+		remaining -= removed
+		return removed, nil
+	})
+
+	total, err := run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(25), total)
+	assert.Equal(t, []int{10, 10, 10}, limits)
+
+	t.Run("Stops on error", func(t *testing.T) {
+		calls := 0
+		run := Batched(10, func(ctx context.Context, limit int) (int64, error) {
+			calls++
+			if calls == 2 {
+				return 0, errors.New("connection reset")
+			}
+			return 10, nil
+		})
+
+		total, err := run(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, int64(10), total)
+	})
+
+	t.Run("Stops when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		run := Batched(10, func(ctx context.Context, limit int) (int64, error) {
+			cancel()
+			return 10, nil
+		})
+
+		total, err := run(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, int64(10), total)
+	})
+}
+
+func TestRunOnce(t *testing.T) {
+	runner := newTestRunner()
+	ctx := context.Background()
+
+	removed := []int64{3, 0}
+	failing := false
+	job := Job{Name: "tokens", Interval: time.Hour, Run: func(ctx context.Context) (int64, error) {
+		if failing {
+			return 0, errors.New("database is down")
+		}
+		n := removed[0]
+		removed = removed[1:]
+		return n, nil
+	}}
+	runner.Add(job)
+
+	assert.True(t, runner.runOnce(ctx, job))
+	assert.True(t, runner.runOnce(ctx, job))
+
+	stats := runner.Stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "tokens", stats[0].Name)
+	assert.Equal(t, int64(2), stats[0].Runs)
+	assert.Equal(t, int64(0), stats[0].LastRemoved)
+	assert.Equal(t, int64(3), stats[0].TotalRemoved)
+	assert.NotNil(t, stats[0].LastRunAt)
+	assert.Empty(t, stats[0].LastError)
+
+	failing = true
+	runner.runOnce(ctx, job)
+	stats = runner.Stats()
+	assert.Equal(t, "database is down", stats[0].LastError)
+	assert.Equal(t, int64(3), stats[0].TotalRemoved)
+
+	t.Run("Skipped while another instance holds the lock", func(t *testing.T) {
+		locker := runner.locker.(*fakeLocker)
+		locker.held["tokens"] = true
+		defer delete(locker.held, "tokens")
+
+		assert.False(t, runner.runOnce(ctx, job))
+		assert.Equal(t, int64(3), runner.Stats()[0].Runs)
+	})
+}
+
+func TestRunnerStartStop(t *testing.T) {
+	runner := newTestRunner()
+
+	ran := make(chan struct{}, 1)
+	runner.Add(Job{Name: "sweep", Interval: time.Hour, Run: func(ctx context.Context) (int64, error) {
+		select {
+		case ran <- struct{}{}:
+		default:
+		}
+		return 1, nil
+	}})
+
+	runner.Start()
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("job did not run at start")
+	}
+
+	runner.Stop()
+	assert.Equal(t, int64(1), runner.Stats()[0].TotalRemoved)
+}
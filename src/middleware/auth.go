@@ -2,16 +2,25 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 
+	"github.com/trevortippery/moving-checklist/auth"
 	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/lockout"
+	"github.com/trevortippery/moving-checklist/tokens"
 	"github.com/trevortippery/moving-checklist/utils"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	apiKeyContextKey = contextKey("apiKey")
+)
 
 func SetUser(r *http.Request, user *db.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -26,47 +35,158 @@ func GetUser(r *http.Request) *db.User {
 	return user
 }
 
+// SetToken records the bearer token the request was authenticated with so
+// handlers can act on the current session, e.g. to revoke it.
+func SetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func GetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// SetAPIKey records the API key a request was authenticated with. Requests
+// without one were made by a signed-in user and carry every permission.
+func SetAPIKey(r *http.Request, key *db.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+func GetAPIKey(r *http.Request) *db.APIKey {
+	key, ok := r.Context().Value(apiKeyContextKey).(*db.APIKey)
+	if !ok {
+		return nil
+	}
+	return key
+}
+
 type AuthMiddleware struct {
-	UserStore db.UserStore
+	Authenticator auth.Authenticator
+	APIKeyStore   db.APIKeyStore
+	// TokenLimiter counts invalid bearer tokens per client IP.
+	TokenLimiter *lockout.Limiter
+	Logger       *log.Logger
 }
 
-func NewAuthMiddleware(userStore db.UserStore) *AuthMiddleware {
+func NewAuthMiddleware(authenticator auth.Authenticator, apiKeyStore db.APIKeyStore, tokenLimiter *lockout.Limiter, logger *log.Logger) *AuthMiddleware {
 	return &AuthMiddleware{
-		UserStore: userStore,
+		Authenticator: authenticator,
+		APIKeyStore:   apiKeyStore,
+		TokenLimiter:  tokenLimiter,
+		Logger:        logger,
 	}
 }
 
 func (am *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "Cookie")
 
+		var token string
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			// No auth header, set anonymous user (nil here)
+		if authHeader != "" {
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid authorization header"})
+				return
+			}
+
+			token = parts[1]
+		} else if cookie, err := r.Cookie(SessionCookie); err == nil {
+			// Browsers attach cookies to cross-site requests too, so only
+			// the CSRF token shows the page asking is our own
+			if !ValidCSRF(r) {
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "missing or invalid CSRF token"})
+				return
+			}
+
+			// API keys are never handed out as cookies
+			if strings.HasPrefix(cookie.Value, tokens.APIKeyPrefix) {
+				utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired token"})
+				return
+			}
+
+			token = cookie.Value
+		} else {
+			// No credentials, set anonymous user (nil here)
 			r = SetUser(r, nil)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid authorization header"})
+		ip := utils.ClientIP(r)
+		retryAfter, err := am.TokenLimiter.RetryAfter(r.Context(), ip)
+		if err != nil {
+			am.Logger.Printf("Error in Authenticate: Checking token lockout - %v", err)
+		}
+
+		if retryAfter > 0 {
+			utils.WriteTooManyRequests(w, retryAfter)
+			return
+		}
+
+		if strings.HasPrefix(token, tokens.APIKeyPrefix) {
+			am.authenticateAPIKey(w, r, next, token)
 			return
 		}
 
-		token := parts[1]
+		// Only unknown or malformed tokens count against the IP. Expired ones
+		// are routine, and many clients can share an address behind NAT
+		user, err := am.Authenticator.Authenticate(r.Context(), token)
+		if err != nil && !errors.Is(err, auth.ErrTokenExpired) {
+			am.Logger.Printf("Error in Authenticate: Authenticating token - %v", err)
+		}
+
+		if err == nil && user == nil {
+			am.failToken(r)
+		}
 
-		user, err := am.UserStore.GetUserByToken(r.Context(), token, "auth")
 		if err != nil || user == nil {
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired token"})
 			return
 		}
 
 		r = SetUser(r, user)
+		r = SetToken(r, token)
 		next.ServeHTTP(w, r)
 	})
 }
 
+func (am *AuthMiddleware) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	user, key, err := am.APIKeyStore.GetUserByAPIKey(r.Context(), plaintext)
+	if err == nil && user == nil {
+		am.failToken(r)
+	}
+
+	if err != nil || user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired api key"})
+		return
+	}
+
+	err = am.APIKeyStore.TouchAPIKey(r.Context(), key.ID, auth.SessionTouchInterval)
+	if err != nil {
+		am.Logger.Printf("Error in Authenticate: Touching api key - %v", err)
+	}
+
+	r = SetUser(r, user)
+	r = SetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
+// failToken counts an invalid bearer token against the client's IP. Store
+// errors only fail the lookup, never the request.
+func (am *AuthMiddleware) failToken(r *http.Request) {
+	_, err := am.TokenLimiter.Fail(r.Context(), utils.ClientIP(r))
+	if err != nil {
+		am.Logger.Printf("Error in Authenticate: Recording invalid token - %v", err)
+	}
+}
+
 // RequireUser middleware to enforce that a user is authenticated
 func RequireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -78,3 +198,79 @@ func RequireUser(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireActivatedUser is RequireUser for deployments that only let users in
+// once they have verified their email address.
+func RequireActivatedUser(next http.Handler) http.Handler {
+	return RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+		if !user.Activated {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "your account must be activated to access this route"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// RequireRole lets through users with role, or admins. It must run after
+// RequireUser.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := GetUser(r)
+			if user == nil || !user.HasRole(role) {
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "you do not have permission to access this route"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission rejects API key requests whose key was not granted
+// permission. Requests made with a session token are always allowed through.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := GetAPIKey(r)
+			if key != nil && !key.HasPermission(permission) {
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "api key is missing the " + permission + " permission"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession rejects requests authenticated with an API key, for routes
+// that manage the account itself and need a signed-in user. If the
+// authenticator trusts token claims, the session and user are also looked up,
+// so these routes refuse revoked sessions and deleted or locked accounts
+// straight away and see the user's current role.
+func (am *AuthMiddleware) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetAPIKey(r) != nil {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "api keys cannot access this route"})
+			return
+		}
+
+		rechecker, ok := am.Authenticator.(auth.Rechecker)
+		if ok && GetUser(r) != nil {
+			user, err := rechecker.Recheck(r.Context(), GetToken(r))
+			if err != nil {
+				am.Logger.Printf("Error in RequireSession: Rechecking token - %v", err)
+				utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "something went wrong"})
+				return
+			}
+
+			if user == nil {
+				utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired token"})
+				return
+			}
+
+			r = SetUser(r, user)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
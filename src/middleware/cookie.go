@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+// Browser sessions keep the auth and refresh tokens in HttpOnly cookies so
+// scripts never see them. Requests that change state must also echo the
+// csrf_token cookie in the X-CSRF-Token header, which a cross-site page cannot
+// read to copy.
+const (
+	SessionCookie = "session"
+	RefreshCookie = "refresh_token"
+	CSRFCookie    = "csrf_token"
+	CSRFHeader    = "X-CSRF-Token"
+
+	// OIDCStateCookie ties a single sign-on callback to the browser that
+	// started it, so nobody can finish their own sign-in in someone else's
+	// browser.
+	OIDCStateCookie = "oidc_state"
+
+	// The refresh token is only sent where it is needed.
+	refreshCookiePath = "/tokens"
+	oidcCookiePath    = "/oidc"
+)
+
+// SessionCookies writes the browser session cookies. Secure should only be
+// turned off for local development over plain HTTP.
+type SessionCookies struct {
+	Secure bool
+}
+
+// Set stores the tokens in cookies that expire along with them and returns the
+// CSRF token the client must send back on state-changing requests.
+func (sc SessionCookies) Set(w http.ResponseWriter, authToken, refreshToken *tokens.Token) (string, error) {
+	csrf := make([]byte, 32)
+	_, err := rand.Read(csrf)
+	if err != nil {
+		return "", err
+	}
+	csrfToken := base64.RawURLEncoding.EncodeToString(csrf)
+
+	http.SetCookie(w, sc.cookie(SessionCookie, authToken.Plaintext, "/", authToken.Expiry, true))
+	http.SetCookie(w, sc.cookie(RefreshCookie, refreshToken.Plaintext, refreshCookiePath, refreshToken.Expiry, true))
+	// The frontend reads this one to fill in the header
+	http.SetCookie(w, sc.cookie(CSRFCookie, csrfToken, "/", refreshToken.Expiry, false))
+
+	return csrfToken, nil
+}
+
+// Clear removes the session cookies, e.g. on logout.
+func (sc SessionCookies) Clear(w http.ResponseWriter) {
+	for _, cookie := range []*http.Cookie{
+		sc.cookie(SessionCookie, "", "/", time.Time{}, true),
+		sc.cookie(RefreshCookie, "", refreshCookiePath, time.Time{}, true),
+		sc.cookie(CSRFCookie, "", "/", time.Time{}, false),
+	} {
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// SetOIDCState remembers the state of a sign-in started at the provider. It
+// is sent with SameSite=Lax, as the callback is a redirect from the provider's
+// site that a strict cookie would not accompany.
+func (sc SessionCookies) SetOIDCState(w http.ResponseWriter, state string, expires time.Time) {
+	cookie := sc.cookie(OIDCStateCookie, state, oidcCookiePath, expires, true)
+	cookie.SameSite = http.SameSiteLaxMode
+	http.SetCookie(w, cookie)
+}
+
+// ClearOIDCState removes the state cookie once the callback has used it.
+func (sc SessionCookies) ClearOIDCState(w http.ResponseWriter) {
+	cookie := sc.cookie(OIDCStateCookie, "", oidcCookiePath, time.Time{}, true)
+	cookie.SameSite = http.SameSiteLaxMode
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+func (sc SessionCookies) cookie(name, value, path string, expires time.Time, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: httpOnly,
+		Secure:   sc.Secure,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// HasSessionCookie reports whether the request came from a browser session.
+func HasSessionCookie(r *http.Request) bool {
+	_, err := r.Cookie(SessionCookie)
+	return err == nil
+}
+
+// ValidOIDCState reports whether state matches the one this browser was given
+// when it started signing in.
+func ValidOIDCState(r *http.Request, state string) bool {
+	cookie, err := r.Cookie(OIDCStateCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) == 1
+}
+
+// ValidCSRF reports whether the X-CSRF-Token header matches the csrf_token
+// cookie. Safe methods never need one.
+func ValidCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	header := r.Header.Get(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
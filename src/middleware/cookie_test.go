@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/auth"
+	"github.com/trevortippery/moving-checklist/db"
+	"github.com/trevortippery/moving-checklist/lockout"
+	"github.com/trevortippery/moving-checklist/tokens"
+)
+
+type stubAuthenticator struct {
+	token   string
+	user    *db.User
+	expired string
+}
+
+func (sa stubAuthenticator) Authenticate(ctx context.Context, token string) (*db.User, error) {
+	if token == sa.token {
+		return sa.user, nil
+	}
+	if token == sa.expired {
+		return nil, auth.ErrTokenExpired
+	}
+	return nil, nil
+}
+
+func (sa stubAuthenticator) NewAccessToken(user *db.User, family string) (*tokens.Token, error) {
+	return nil, nil
+}
+
+func TestSessionCookies(t *testing.T) {
+	authToken := &tokens.Token{Plaintext: "auth", Expiry: time.Now().Add(time.Hour)}
+	refreshToken := &tokens.Token{Plaintext: "refresh", Expiry: time.Now().Add(24 * time.Hour)}
+
+	rec := httptest.NewRecorder()
+	csrfToken, err := SessionCookies{Secure: true}.Set(rec, authToken, refreshToken)
+	require.NoError(t, err)
+	assert.NotEmpty(t, csrfToken)
+
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	require.Len(t, cookies, 3)
+
+	for _, cookie := range cookies {
+		assert.True(t, cookie.Secure, cookie.Name)
+		assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite, cookie.Name)
+	}
+
+	assert.Equal(t, "auth", cookies[SessionCookie].Value)
+	assert.True(t, cookies[SessionCookie].HttpOnly)
+	assert.Equal(t, "refresh", cookies[RefreshCookie].Value)
+	assert.True(t, cookies[RefreshCookie].HttpOnly)
+	assert.Equal(t, "/tokens", cookies[RefreshCookie].Path)
+	assert.Equal(t, csrfToken, cookies[CSRFCookie].Value)
+	assert.False(t, cookies[CSRFCookie].HttpOnly, "the frontend has to read the CSRF token")
+
+	rec = httptest.NewRecorder()
+	SessionCookies{Secure: true}.Clear(rec)
+	cleared := rec.Result().Cookies()
+	require.Len(t, cleared, 3)
+	for _, cookie := range cleared {
+		assert.Empty(t, cookie.Value, cookie.Name)
+		assert.Negative(t, cookie.MaxAge, cookie.Name)
+	}
+}
+
+func TestOIDCStateCookie(t *testing.T) {
+	rec := httptest.NewRecorder()
+	SessionCookies{Secure: true}.SetOIDCState(rec, "state", time.Now().Add(10*time.Minute))
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.True(t, cookies[0].Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite, "the callback is a cross-site redirect")
+	assert.Equal(t, "/oidc", cookies[0].Path)
+
+	req := httptest.NewRequest(http.MethodGet, "/oidc/callback", nil)
+	assert.False(t, ValidOIDCState(req, "state"), "no cookie")
+
+	req.AddCookie(cookies[0])
+	assert.True(t, ValidOIDCState(req, "state"))
+	assert.False(t, ValidOIDCState(req, "other"))
+	assert.False(t, ValidOIDCState(req, ""))
+}
+
+func TestAuthenticateSessionCookie(t *testing.T) {
+	user := &db.User{ID: 1, Username: "mover"}
+	am := NewAuthMiddleware(
+		stubAuthenticator{token: "valid", user: user},
+		nil,
+		lockout.NewLimiter(lockout.NewMemoryStore(), "token-ip:", lockout.Policy{}),
+		log.New(io.Discard, "", 0),
+	)
+
+	handler := am.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetUser(r) == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		session string
+		csrf    string
+		header  string
+		want    int
+	}{
+		{"Read without CSRF token", http.MethodGet, "valid", "", "", http.StatusOK},
+		{"Write with CSRF token", http.MethodPost, "valid", "abc", "abc", http.StatusOK},
+		{"Write without CSRF header", http.MethodPost, "valid", "abc", "", http.StatusForbidden},
+		{"Write with wrong CSRF header", http.MethodDelete, "valid", "abc", "abd", http.StatusForbidden},
+		{"Write without CSRF cookie", http.MethodPut, "valid", "", "abc", http.StatusForbidden},
+		{"Invalid session", http.MethodGet, "expired", "", "", http.StatusUnauthorized},
+		{"API key in cookie", http.MethodGet, tokens.APIKeyPrefix + "key", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/tasks", nil)
+			req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.session})
+			if tt.csrf != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.csrf})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	t.Run("Bearer token needs no CSRF token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", nil)
+		req.Header.Set("Authorization", "Bearer valid")
+		req.AddCookie(&http.Cookie{Name: SessionCookie, Value: "expired"})
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func TestAuthenticateCountsOnlyUnknownTokens(t *testing.T) {
+	am := NewAuthMiddleware(
+		stubAuthenticator{token: "valid", user: &db.User{ID: 1}, expired: "expired"},
+		nil,
+		lockout.NewLimiter(lockout.NewMemoryStore(), "token-ip:", lockout.Policy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}),
+		log.New(io.Discard, "", 0),
+	)
+
+	handler := am.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Clients sharing an address keep presenting expired tokens
+	for range 10 {
+		assert.Equal(t, http.StatusUnauthorized, request("expired"))
+	}
+	assert.Equal(t, http.StatusOK, request("valid"))
+
+	for range 3 {
+		assert.Equal(t, http.StatusUnauthorized, request("guess"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request("valid"))
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevortippery/moving-checklist/utils"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("")
+	require.NoError(t, err)
+	assert.Empty(t, proxies)
+
+	proxies, err = ParseTrustedProxies("10.0.0.0/8, 192.168.1.5,::1")
+	require.NoError(t, err)
+	require.Len(t, proxies, 3)
+	assert.Equal(t, "10.0.0.0/8", proxies[0].String())
+	assert.Equal(t, "192.168.1.5/32", proxies[1].String())
+	assert.Equal(t, "::1/128", proxies[2].String())
+
+	_, err = ParseTrustedProxies("10.0.0.0/8,proxy.local")
+	assert.Error(t, err)
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		trusted   bool
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"No trusted proxies", false, "10.0.0.1:1234", []string{"203.0.113.7"}, "10.0.0.1"},
+		{"Untrusted peer", true, "198.51.100.2:1234", []string{"203.0.113.7"}, "198.51.100.2"},
+		{"Trusted peer", true, "10.0.0.1:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"Chain of proxies", true, "10.0.0.1:1234", []string{"203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"Forged hops are ignored", true, "10.0.0.1:1234", []string{"1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"Repeated headers", true, "10.0.0.1:1234", []string{"1.2.3.4", "203.0.113.7"}, "203.0.113.7"},
+		{"Missing header", true, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"Garbage header", true, "10.0.0.1:1234", []string{"unknown"}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies := trusted
+			if !tt.trusted {
+				proxies = nil
+			}
+
+			var got string
+			handler := RealIP(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = utils.ClientIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Token hashes are now the raw SHA-256 bytes produced by the tokens package.
+-- Existing rows stored the same digest as URL-safe base64, so decode them in
+-- place rather than logging everybody out.
+ALTER TABLE tokens ADD COLUMN hash BYTEA;
+
+UPDATE tokens SET hash = decode(translate(token, '-_', '+/'), 'base64');
+
+ALTER TABLE tokens
+  DROP CONSTRAINT tokens_pkey,
+  DROP COLUMN token,
+  ALTER COLUMN hash SET NOT NULL,
+  ADD PRIMARY KEY (hash);
+
+UPDATE tokens SET scope = 'authentication' WHERE scope = 'auth';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tokens ADD COLUMN token CHAR(44);
+
+UPDATE tokens SET token = translate(encode(hash, 'base64'), '+/', '-_');
+
+ALTER TABLE tokens
+  DROP CONSTRAINT tokens_pkey,
+  DROP COLUMN hash,
+  ALTER COLUMN token SET NOT NULL,
+  ADD PRIMARY KEY (token);
+
+UPDATE tokens SET scope = 'auth' WHERE scope = 'authentication';
+-- +goose StatementEnd
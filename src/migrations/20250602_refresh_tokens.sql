@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tokens issued together at login share a family so that a replayed refresh
+-- token can revoke everything descended from the same sign-in.
+ALTER TABLE tokens
+  ADD COLUMN family TEXT,
+  ADD COLUMN used_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_tokens_family ON tokens(family);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_tokens_family;
+
+ALTER TABLE tokens
+  DROP COLUMN IF EXISTS used_at,
+  DROP COLUMN IF EXISTS family;
+-- +goose StatementEnd
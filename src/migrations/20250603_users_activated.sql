@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Existing accounts predate email verification and are treated as activated;
+-- new registrations start out inactive.
+ALTER TABLE users ADD COLUMN activated BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ALTER COLUMN activated SET DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS activated;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tokens
+  ADD COLUMN id BIGSERIAL UNIQUE,
+  ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+  ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+  ADD COLUMN ip VARCHAR(45) NOT NULL DEFAULT '',
+  ADD COLUMN device_name VARCHAR(100) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tokens
+  DROP COLUMN IF EXISTS device_name,
+  DROP COLUMN IF EXISTS ip,
+  DROP COLUMN IF EXISTS user_agent,
+  DROP COLUMN IF EXISTS last_used_at,
+  DROP COLUMN IF EXISTS id;
+-- +goose StatementEnd
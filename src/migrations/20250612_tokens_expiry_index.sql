@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Lets the maintenance runner find expired tokens without scanning the table.
+CREATE INDEX IF NOT EXISTS idx_tokens_expiry ON tokens(expiry);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_tokens_expiry;
+-- +goose StatementEnd
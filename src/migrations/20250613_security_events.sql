@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Like admin_audit_log, entries outlive the users they mention, so neither id
+-- is a foreign key
+CREATE TABLE IF NOT EXISTS security_events (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT DEFAULT NULL,
+  actor_id BIGINT DEFAULT NULL,
+  event VARCHAR(50) NOT NULL,
+  details JSONB NOT NULL DEFAULT '{}',
+  ip VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_user ON security_events(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_security_events_created ON security_events(created_at);
+
+-- The log is append-only: rows can be added but never changed or removed
+CREATE OR REPLACE FUNCTION security_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'security_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER security_events_append_only
+  BEFORE UPDATE OR DELETE ON security_events
+  FOR EACH ROW EXECUTE FUNCTION security_events_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS security_events;
+DROP FUNCTION IF EXISTS security_events_append_only();
+-- +goose StatementEnd
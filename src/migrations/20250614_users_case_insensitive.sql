@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Usernames and emails are unique regardless of case. Accounts that already
+-- differ only by case have to be resolved by hand before this can run, so
+-- list them rather than pick one to rename
+DO $$
+DECLARE
+  collisions TEXT;
+BEGIN
+  SELECT string_agg(kind || ' ' || value || ' (ids ' || ids || ')', ', ')
+  INTO collisions
+  FROM (
+    SELECT 'username' AS kind, LOWER(username) AS value, string_agg(id::TEXT, ', ' ORDER BY id) AS ids
+    FROM users
+    GROUP BY LOWER(username)
+    HAVING COUNT(*) > 1
+    UNION ALL
+    SELECT 'email', LOWER(email), string_agg(id::TEXT, ', ' ORDER BY id)
+    FROM users
+    GROUP BY LOWER(email)
+    HAVING COUNT(*) > 1
+  ) AS duplicates;
+
+  IF collisions IS NOT NULL THEN
+    RAISE EXCEPTION 'users differ only by case: %', collisions;
+  END IF;
+END
+$$;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users (LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (LOWER(email));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_username_lower_key;
+DROP INDEX IF EXISTS users_email_lower_key;
+
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+-- +goose StatementEnd
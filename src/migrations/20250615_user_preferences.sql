@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Users without a row have the defaults
+CREATE TABLE IF NOT EXISTS user_preferences (
+  user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+  locale VARCHAR(35) NOT NULL DEFAULT 'en-US',
+  date_format VARCHAR(20) NOT NULL DEFAULT 'YYYY-MM-DD',
+  week_start VARCHAR(10) NOT NULL DEFAULT 'monday' CHECK (week_start IN ('monday', 'sunday', 'saturday')),
+  reminder_lead_minutes INTEGER NOT NULL DEFAULT 1440 CHECK (reminder_lead_minutes >= 0),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A task due all day is due on a calendar date, which stays the same day when
+-- the user changes time zone, and is only overdue once that day is over
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_on DATE;
+ALTER TABLE tasks ADD CONSTRAINT tasks_due_date_or_due_on CHECK (due_date IS NULL OR due_on IS NULL);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_due_date_or_due_on;
+ALTER TABLE tasks DROP COLUMN IF EXISTS due_on;
+DROP TABLE IF EXISTS user_preferences;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Whether a single sign-on finishes with session cookies instead of tokens.
+ALTER TABLE oidc_login_states ADD COLUMN IF NOT EXISTS cookie BOOLEAN NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE oidc_login_states DROP COLUMN IF EXISTS cookie;
+-- +goose StatementEnd
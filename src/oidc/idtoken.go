@@ -0,0 +1,271 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew tolerates small clock differences between us and the provider.
+const clockSkew = time.Minute
+
+var errMalformedIDToken = errors.New("oidc: malformed id token")
+
+// Claims are the ID token claims we act on.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// audience accepts both the single string and the array form of aud.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	if err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+type idTokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's JWKS and
+// validates its issuer, audience, lifetime and nonce.
+func (c *Client) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedIDToken
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedIDToken
+	}
+
+	var header idTokenHeader
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil {
+		return nil, errMalformedIDToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedIDToken
+	}
+
+	key, err := c.signingKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Algorithm {
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("oidc: key %q is not an RSA key", header.KeyID)
+		}
+		err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)
+		if err != nil {
+			return nil, errors.New("oidc: invalid id token signature")
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, fmt.Errorf("oidc: key %q is not a P-256 key", header.KeyID)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return nil, errors.New("oidc: invalid id token signature")
+		}
+	default:
+		// Never accept "none" or symmetric algorithms keyed with public material
+		return nil, fmt.Errorf("oidc: unsupported id token algorithm %q", header.Algorithm)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedIDToken
+	}
+
+	var claims Claims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, errMalformedIDToken
+	}
+
+	err = c.validateClaims(&claims, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func (c *Client) validateClaims(claims *Claims, nonce string) error {
+	if strings.TrimSuffix(claims.Issuer, "/") != c.config.IssuerURL {
+		return fmt.Errorf("oidc: unexpected issuer %q", claims.Issuer)
+	}
+
+	if claims.Subject == "" {
+		return errors.New("oidc: id token has no subject")
+	}
+
+	found := false
+	for _, aud := range claims.Audience {
+		if aud == c.config.ClientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("oidc: id token was not issued for this client")
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != c.config.ClientID {
+		return errors.New("oidc: id token authorized party does not match this client")
+	}
+
+	now := c.now()
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("oidc: id token expired")
+	}
+
+	if now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return errors.New("oidc: id token issued in the future")
+	}
+
+	if claims.Nonce != nonce {
+		return errors.New("oidc: id token nonce mismatch")
+	}
+
+	return nil
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// signingKey returns the provider key with the given ID. The key set is
+// refetched once when an unknown ID shows up so provider key rotation is
+// picked up without a restart.
+func (c *Client) signingKey(ctx context.Context, keyID string) (any, error) {
+	c.mu.Lock()
+	key, ok := c.keys[keyID]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	discovery, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = c.getJSON(ctx, discovery.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching jwks: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		publicKey, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = publicKey
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+
+	key, ok = keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", keyID)
+	}
+	return key, nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("oidc: rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, errors.New("oidc: ec point is not on the curve")
+		}
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("oidc: unsupported key type %q", k.KeyType)
+	}
+}
@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockProvider is a minimal OIDC provider that hands out an ID token for a
+// single pending authorization code.
+type mockProvider struct {
+	t        *testing.T
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	keyID    string
+	clientID string
+
+	// Set by the test before the code is exchanged
+	code          string
+	codeChallenge string
+	claims        map[string]any
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mp := &mockProvider{t: t, key: key, keyID: "mock-1", clientID: "client"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 mp.server.URL,
+			"authorization_endpoint": mp.server.URL + "/authorize",
+			"token_endpoint":         mp.server.URL + "/token",
+			"jwks_uri":               mp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": mp.keyID,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(mp.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(mp.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != mp.code || CodeChallenge(r.Form.Get("code_verifier")) != mp.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": mp.sign(mp.claims)})
+	})
+
+	mp.server = httptest.NewServer(mux)
+	t.Cleanup(mp.server.Close)
+
+	return mp
+}
+
+func (mp *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": mp.keyID})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, mp.key, crypto.SHA256, digest[:])
+	require.NoError(mp.t, err)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (mp *mockProvider) validClaims(nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":                mp.server.URL,
+		"sub":                "external-123",
+		"aud":                mp.clientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"email":              "example@example.com",
+		"email_verified":     true,
+		"preferred_username": "example",
+	}
+}
+
+func (mp *mockProvider) client() *Client {
+	return NewClient(Config{
+		IssuerURL:    mp.server.URL,
+		ClientID:     mp.clientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oidc/callback",
+		Scopes:       []string{"email", "profile"},
+	}, mp.server.Client())
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	mp := newMockProvider(t)
+	client := mp.client()
+	ctx := context.Background()
+
+	verifier, err := RandomString()
+	require.NoError(t, err)
+
+	authURL, err := client.AuthCodeURL(ctx, "state-1", "nonce-1", CodeChallenge(verifier))
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "/authorize", parsed.Path)
+	assert.Equal(t, "code", parsed.Query().Get("response_type"))
+	assert.Equal(t, "openid email profile", parsed.Query().Get("scope"))
+	assert.Equal(t, "state-1", parsed.Query().Get("state"))
+	assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+
+	mp.code = "code-1"
+	mp.codeChallenge = parsed.Query().Get("code_challenge")
+	mp.claims = mp.validClaims("nonce-1")
+
+	claims, err := client.Exchange(ctx, "code-1", verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "external-123", claims.Subject)
+	assert.Equal(t, "example@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	// The provider rejects a verifier that does not match the challenge
+	_, err = client.Exchange(ctx, "code-1", "wrong-verifier", "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	mp := newMockProvider(t)
+	client := mp.client()
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		mutate func(claims map[string]any)
+		token  func(claims map[string]any) string
+	}{
+		{name: "wrong nonce", mutate: func(c map[string]any) { c["nonce"] = "other" }},
+		{name: "wrong audience", mutate: func(c map[string]any) { c["aud"] = "someone-else" }},
+		{name: "multiple audiences without azp", mutate: func(c map[string]any) { c["aud"] = []string{"client", "other"} }},
+		{name: "wrong issuer", mutate: func(c map[string]any) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", mutate: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "missing subject", mutate: func(c map[string]any) { delete(c, "sub") }},
+		{
+			name: "unsigned",
+			token: func(c map[string]any) string {
+				header, _ := json.Marshal(map[string]string{"alg": "none", "kid": mp.keyID})
+				payload, _ := json.Marshal(c)
+				return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+			},
+		},
+		{
+			name: "tampered",
+			token: func(c map[string]any) string {
+				parts := strings.Split(mp.sign(c), ".")
+				c["sub"] = "someone-else"
+				payload, _ := json.Marshal(c)
+				return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			},
+		},
+	}
+
+	valid := mp.sign(mp.validClaims("nonce"))
+	_, err := client.VerifyIDToken(ctx, valid, "nonce")
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := mp.validClaims("nonce")
+			var raw string
+			if tt.token != nil {
+				raw = tt.token(claims)
+			} else {
+				tt.mutate(claims)
+				raw = mp.sign(claims)
+			}
+
+			_, err := client.VerifyIDToken(ctx, raw, "nonce")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "https://elsewhere.example.com",
+			"authorization_endpoint": "https://elsewhere.example.com/authorize",
+			"token_endpoint":         "https://elsewhere.example.com/token",
+			"jwks_uri":               "https://elsewhere.example.com/jwks",
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{IssuerURL: server.URL, ClientID: "client"}, server.Client())
+	_, err := client.Discover(context.Background())
+	assert.Error(t, err)
+}
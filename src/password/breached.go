@@ -0,0 +1,78 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLength is how many hex characters of the SHA-1 hash name the range
+// file, as in the Have I Been Pwned range API.
+const prefixLength = 5
+
+// BreachedList looks passwords up in a directory of SHA-1 range files laid
+// out like the Have I Been Pwned range API: the file named after the first
+// five hex characters of a hash (optionally with a .txt extension) lists the
+// remaining 35 as SUFFIX:COUNT lines. Only the one file for a password's
+// prefix is read per lookup, so the full list never has to fit in memory.
+type BreachedList struct {
+	dir string
+}
+
+// OpenBreachedList checks that dir is a readable directory.
+func OpenBreachedList(dir string) (*BreachedList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("password: breached list: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("password: breached list %s is not a directory", dir)
+	}
+
+	return &BreachedList{dir: dir}, nil
+}
+
+// Contains reports whether password's hash is listed with a non-zero count.
+// A missing range file means no listed password has that prefix.
+func (bl *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	file, err := bl.open(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, count, _ := strings.Cut(line, ":")
+
+		// Padding entries in downloaded ranges have a count of zero
+		if strings.EqualFold(candidate, suffix) && strings.TrimLeft(count, "0") != "" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func (bl *BreachedList) open(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(bl.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(bl.dir, prefix))
+	}
+	return file, err
+}
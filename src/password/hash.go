@@ -0,0 +1,177 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher turns passwords into stored hashes and checks passwords against
+// them.
+type Hasher interface {
+	Hash(password []byte) (string, error)
+	// Verify reports whether password matches hash and, if it does, whether
+	// hash was made with an outdated algorithm or parameters and should be
+	// replaced with a fresh Hash of the same password.
+	Verify(hash string, password []byte) (match bool, rehash bool, err error)
+}
+
+// Argon2idParams tune the cost of argon2id. Memory is in KiB.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation of 19 MiB, two
+// passes and one lane.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (p Argon2idParams) Validate() error {
+	if p.Iterations < 1 {
+		return errors.New("password: argon2id iterations must be at least 1")
+	}
+	if p.Parallelism < 1 {
+		return errors.New("password: argon2id parallelism must be at least 1")
+	}
+	if p.Memory < 8*uint32(p.Parallelism) {
+		return errors.New("password: argon2id memory must be at least 8 KiB per lane")
+	}
+	if p.SaltLength < 8 || p.KeyLength < 16 {
+		return errors.New("password: argon2id salt must be at least 8 bytes and key at least 16 bytes")
+	}
+	return nil
+}
+
+var ErrUnknownHash = errors.New("password: unknown hash format")
+
+// Argon2idHasher stores new hashes as argon2id in the PHC string format,
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//
+// and still verifies bcrypt hashes from before argon2id was introduced.
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) (*Argon2idHasher, error) {
+	err := params.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &Argon2idHasher{params: params}, nil
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (ah *Argon2idHasher) Hash(password []byte) (string, error) {
+	salt := make([]byte, ah.params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey(password, salt, ah.params.Iterations, ah.params.Memory, ah.params.Parallelism, ah.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		ah.params.Memory,
+		ah.params.Iterations,
+		ah.params.Parallelism,
+		phcEncoding.EncodeToString(salt),
+		phcEncoding.EncodeToString(key),
+	), nil
+}
+
+func (ah *Argon2idHasher) Verify(hash string, password []byte) (bool, bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return ah.verifyArgon2id(hash, password)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		match, err := verifyBcrypt(hash, password)
+		return match, match, err
+	default:
+		return false, false, ErrUnknownHash
+	}
+}
+
+func (ah *Argon2idHasher) verifyArgon2id(hash string, password []byte) (bool, bool, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnknownHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return false, false, fmt.Errorf("password: argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return false, false, fmt.Errorf("password: unsupported argon2id version %d", version)
+	}
+
+	var params Argon2idParams
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return false, false, fmt.Errorf("password: argon2id parameters: %w", err)
+	}
+
+	salt, err := phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("password: argon2id salt: %w", err)
+	}
+
+	key, err := phcEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("password: argon2id key: %w", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	err = params.Validate()
+	if err != nil {
+		return false, false, err
+	}
+
+	candidate := argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, false, nil
+	}
+
+	return true, params != ah.params, nil
+}
+
+// BcryptMaxBytes is the longest password bcrypt hashes in full; it ignores
+// anything after it.
+const BcryptMaxBytes = 72
+
+func verifyBcrypt(hash string, password []byte) (bool, error) {
+	// bcrypt hashes were only ever made from passwords up to the limit
+	if len(password) > BcryptMaxBytes {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
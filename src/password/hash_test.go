@@ -0,0 +1,109 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast; they are far too cheap for real use.
+var testParams = Argon2idParams{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher, err := NewArgon2idHasher(testParams)
+	require.NoError(t, err)
+
+	hash, err := hasher.Hash([]byte("tape and boxes"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"))
+
+	again, err := hasher.Hash([]byte("tape and boxes"))
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, again, "each hash gets its own salt")
+
+	match, rehash, err := hasher.Verify(hash, []byte("tape and boxes"))
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	match, rehash, err = hasher.Verify(hash, []byte("tape and boxez"))
+	require.NoError(t, err)
+	assert.False(t, match)
+	assert.False(t, rehash)
+
+	t.Run("Changed parameters", func(t *testing.T) {
+		stronger := testParams
+		stronger.Iterations = 2
+		upgraded, err := NewArgon2idHasher(stronger)
+		require.NoError(t, err)
+
+		match, rehash, err := upgraded.Verify(hash, []byte("tape and boxes"))
+		require.NoError(t, err)
+		assert.True(t, match)
+		assert.True(t, rehash)
+	})
+
+	t.Run("Bcrypt", func(t *testing.T) {
+		legacy, err := bcrypt.GenerateFromPassword([]byte("tape and boxes"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		match, rehash, err := hasher.Verify(string(legacy), []byte("tape and boxes"))
+		require.NoError(t, err)
+		assert.True(t, match)
+		assert.True(t, rehash)
+
+		match, rehash, err = hasher.Verify(string(legacy), []byte("wrong"))
+		require.NoError(t, err)
+		assert.False(t, match)
+		assert.False(t, rehash)
+
+		// bcrypt would ignore everything past the limit
+		long := strings.Repeat("a", BcryptMaxBytes)
+		legacy, err = bcrypt.GenerateFromPassword([]byte(long), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		match, _, err = hasher.Verify(string(legacy), []byte(long+"b"))
+		require.NoError(t, err)
+		assert.False(t, match)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, hash := range []string{
+			"",
+			"plaintext",
+			"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5",
+			"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+			"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5a2V5a2V5",
+			"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5a2V5a2V5",
+			"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5a2V5a2V5a2V5a2V5",
+		} {
+			match, _, err := hasher.Verify(hash, []byte("tape and boxes"))
+			assert.Error(t, err, hash)
+			assert.False(t, match, hash)
+		}
+	})
+}
+
+func TestArgon2idParamsValidate(t *testing.T) {
+	assert.NoError(t, DefaultArgon2idParams.Validate())
+
+	invalid := testParams
+	invalid.Parallelism = 0
+	assert.Error(t, invalid.Validate())
+
+	invalid = testParams
+	invalid.Memory = 4
+	assert.Error(t, invalid.Validate())
+
+	_, err := NewArgon2idHasher(invalid)
+	assert.Error(t, err)
+}
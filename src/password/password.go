@@ -0,0 +1,56 @@
+// Package password decides which passwords users may choose and how they are
+// hashed.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxBytes is the highest Policy.MaxLength allowed. Unlike bcrypt, argon2id
+// uses every byte of a password, so the limit only keeps requests a sensible
+// size.
+const MaxBytes = 1024
+
+type Policy struct {
+	// MinLength is counted in characters and MaxLength in bytes, since the
+	// byte length is what costs hashing time.
+	MinLength int
+	MaxLength int
+	// Breached is consulted when set.
+	Breached *BreachedList
+}
+
+// Check returns why password is not acceptable for the user, or an empty
+// string if it is. An error means the breached password list could not be
+// read.
+func (p *Policy) Check(password, username, email string) (string, error) {
+	if strings.TrimSpace(password) == "" {
+		return "password is required", nil
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Sprintf("password must be at least %d characters", p.MinLength), nil
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Sprintf("password must be at most %d bytes", p.MaxLength), nil
+	}
+
+	for _, identifier := range []string{username, email} {
+		if identifier != "" && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(identifier)) {
+			return "password must not be your username or email", nil
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return "", err
+		}
+		if breached {
+			return "password has appeared in a data breach, choose a different one", nil
+		}
+	}
+
+	return "", nil
+}
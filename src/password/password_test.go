@@ -0,0 +1,90 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+func writeBreachedList(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(strings.Join([]string{
+		"003D68EB55068C33ACE09247EE4C639306B:3",
+		"1e4c9b93f3f0682250b6cf8331b7ee68fd8:10434004",
+		"",
+	}, "\r\n")), 0o600))
+
+	// Padding entries with a zero count are not breaches
+	// SHA-1 of "letmein" is B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "B7A87"), []byte("5FC1EA228B9061041B7CEC4BD3C52AB3CE3:0\n"), 0o600))
+
+	return dir
+}
+
+func TestBreachedList(t *testing.T) {
+	list, err := OpenBreachedList(writeBreachedList(t))
+	require.NoError(t, err)
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"letmein", false},
+		{"correct horse battery staple", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got, err := list.Contains(tt.password)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err = OpenBreachedList(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestPolicy(t *testing.T) {
+	list, err := OpenBreachedList(writeBreachedList(t))
+	require.NoError(t, err)
+
+	policy := &Policy{MinLength: 8, MaxLength: 72, Breached: list}
+
+	tests := []struct {
+		name     string
+		password string
+		wantOK   bool
+	}{
+		{"Acceptable", "tape and boxes", true},
+		{"Empty", "   ", false},
+		{"Too short", "short", false},
+		{"Multibyte characters count once", "ümzüglich", true},
+		{"Exactly the byte limit", strings.Repeat("a", 72), true},
+		{"Over the byte limit", strings.Repeat("a", 73), false},
+		{"Over the byte limit in multibyte characters", strings.Repeat("ü", 37), false},
+		{"Same as username", "MovingDay2025", false},
+		{"Same as email", "mover@example.com", false},
+		{"Breached", "password", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := policy.Check(tt.password, "movingday2025", "Mover@Example.com")
+			require.NoError(t, err)
+			if tt.wantOK {
+				assert.Empty(t, msg)
+			} else {
+				assert.NotEmpty(t, msg)
+			}
+		})
+	}
+}
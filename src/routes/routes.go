@@ -3,33 +3,115 @@ package routes
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/trevortippery/moving-checklist/app"
+	"github.com/trevortippery/moving-checklist/db"
 	"github.com/trevortippery/moving-checklist/middleware"
+	"github.com/trevortippery/moving-checklist/tokens"
 )
 
 func SetupRoutes(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(middleware.RealIP(app.TrustedProxies))
+
+	requireUser := middleware.RequireUser
+	if app.Config.RequireActivation {
+		requireUser = middleware.RequireActivatedUser
+	}
 
 	// Tasks routes - require auth
 	r.Route("/tasks", func(r chi.Router) {
 		r.Use(app.Middleware.Authenticate)
-		r.Use(middleware.RequireUser)
+		r.Use(requireUser)
+
+		r.With(middleware.RequirePermission(tokens.PermissionTasksWrite)).Post("/", app.TaskHandler.HandleCreateTask)
+		r.With(middleware.RequirePermission(tokens.PermissionTasksWrite)).Put("/{id}", app.TaskHandler.HandleUpdateTask)
+		r.With(middleware.RequirePermission(tokens.PermissionTasksWrite)).Delete("/{id}", app.TaskHandler.HandleDeleteTask)
+		r.With(middleware.RequirePermission(tokens.PermissionTasksRead)).Get("/{id}", app.TaskHandler.HandleGetTaskByID)
+	})
 
-		r.Post("/", app.TaskHandler.HandleCreateTask)
-		r.Put("/{id}", app.TaskHandler.HandleUpdateTask)
-		r.Delete("/{id}", app.TaskHandler.HandleDeleteTask)
-		r.Get("/{id}", app.TaskHandler.HandleGetTaskByID)
+	r.Route("/tokens", func(r chi.Router) {
+		// Logging in is public
+		r.Post("/authentication", app.TokenHandler.HandleCreateToken)
+		r.Post("/refresh", app.TokenHandler.HandleRefreshToken)
+		r.Post("/2fa", app.TokenHandler.HandleCreateTwoFactorToken)
+		r.Post("/password-reset", app.TokenHandler.HandleCreatePasswordResetToken)
+
+		// Revoking tokens - require auth
+		r.Group(func(r chi.Router) {
+			r.Use(app.Middleware.Authenticate)
+			r.Use(middleware.RequireUser)
+			r.Use(app.Middleware.RequireSession)
+
+			r.Delete("/", app.TokenHandler.HandleDeleteAllTokens)
+			r.Delete("/current", app.TokenHandler.HandleDeleteCurrentToken)
+		})
 	})
 
-	// User registration is public
-	r.Post("/users", app.UserHandler.HandleRegisterUser)
+	// Single sign-on through an external OpenID Connect provider
+	r.Route("/oidc", func(r chi.Router) {
+		r.Get("/login", app.OIDCHandler.HandleLogin)
+		r.Get("/callback", app.OIDCHandler.HandleCallback)
+	})
 
-	// User routes - require auth
 	r.Route("/users", func(r chi.Router) {
+		// User registration, activation, password resets and restoring a
+		// deleted account are public
+		r.Post("/", app.UserHandler.HandleRegisterUser)
+		r.Put("/activated", app.UserHandler.HandleActivateUser)
+		r.Put("/password", app.UserHandler.HandleResetPassword)
+		r.Post("/restore", app.UserHandler.HandleRestoreUser)
+
+		// Reading the profile and preferences is also open to API keys with
+		// users:read
+		r.Group(func(r chi.Router) {
+			r.Use(app.Middleware.Authenticate)
+			r.Use(middleware.RequireUser)
+			r.Use(middleware.RequirePermission(tokens.PermissionUsersRead))
+
+			r.Get("/me", app.UserHandler.HandleGetCurrentUser)
+			r.Get("/me/preferences", app.PreferencesHandler.HandleGetPreferences)
+		})
+
+		// User routes - require auth from a signed-in user, not an API key
+		r.Group(func(r chi.Router) {
+			r.Use(app.Middleware.Authenticate)
+			r.Use(middleware.RequireUser)
+			r.Use(app.Middleware.RequireSession)
+
+			r.Delete("/me", app.UserHandler.HandleDeleteUser)
+			r.Put("/me", app.UserHandler.HandleUpdateUser)
+			r.Get("/me/export", app.UserHandler.HandleExportUser)
+			r.Get("/me/security-events", app.UserHandler.HandleListSecurityEvents)
+			r.Put("/me/preferences", app.PreferencesHandler.HandleUpdatePreferences)
+
+			r.Get("/me/sessions", app.SessionHandler.HandleListSessions)
+			r.Delete("/me/sessions/{id}", app.SessionHandler.HandleDeleteSession)
+
+			r.Post("/me/api-keys", app.APIKeyHandler.HandleCreateAPIKey)
+			r.Get("/me/api-keys", app.APIKeyHandler.HandleListAPIKeys)
+			r.Delete("/me/api-keys/{id}", app.APIKeyHandler.HandleDeleteAPIKey)
+
+			r.Post("/me/2fa/totp", app.TwoFactorHandler.HandleEnrollTOTP)
+			r.Post("/me/2fa/totp/confirm", app.TwoFactorHandler.HandleConfirmTOTP)
+			r.Delete("/me/2fa/totp", app.TwoFactorHandler.HandleDisableTOTP)
+		})
+	})
+
+	// Admin routes - require a signed-in admin; every action is audited
+	r.Route("/admin", func(r chi.Router) {
 		r.Use(app.Middleware.Authenticate)
 		r.Use(middleware.RequireUser)
+		r.Use(app.Middleware.RequireSession)
+		r.Use(middleware.RequireRole(db.RoleAdmin))
 
-		r.Delete("/me", app.UserHandler.HandleDeleteUser)
-		r.Put("/me", app.UserHandler.HandleUpdateUser)
+		r.Get("/users", app.AdminHandler.HandleListUsers)
+		r.Get("/users/{id}", app.AdminHandler.HandleGetUser)
+		r.Post("/users/{id}/lock", app.AdminHandler.HandleLockUser)
+		r.Post("/users/{id}/unlock", app.AdminHandler.HandleUnlockUser)
+		r.Put("/users/{id}/role", app.AdminHandler.HandleSetRole)
+		r.Delete("/users/{id}/tokens", app.AdminHandler.HandleRevokeTokens)
+		r.Get("/audit-log", app.AdminHandler.HandleListAuditLog)
+		r.Get("/security-events", app.AdminHandler.HandleListSecurityEvents)
+		r.Get("/maintenance", app.AdminHandler.HandleGetMaintenance)
 	})
 
 	return r
@@ -8,17 +8,63 @@ import (
 )
 
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int       `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID         int64      `json:"-"`
+	Plaintext  string     `json:"token"`
+	Hash       []byte     `json:"-"`
+	UserID     int        `json:"-"`
+	Expiry     time.Time  `json:"expiry"`
+	Scope      string     `json:"-"`
+	Family     string     `json:"-"`
+	CreatedAt  time.Time  `json:"-"`
+	LastUsedAt *time.Time `json:"-"`
+	UserAgent  string     `json:"-"`
+	IP         string     `json:"-"`
+	DeviceName string     `json:"-"`
 }
 
 const (
-	ScopeAuth = "authentication"
+	ScopeAuth          = "authentication"
+	ScopeRefresh       = "refresh"
+	ScopeActivation    = "activation"
+	ScopePasswordReset = "password-reset"
+	ScopeTwoFactor     = "2fa-pending"
+	ScopeRestore       = "account-restore"
 )
 
+var Scopes = []string{
+	ScopeAuth,
+	ScopeRefresh,
+	ScopeActivation,
+	ScopePasswordReset,
+	ScopeTwoFactor,
+	ScopeRestore,
+}
+
+const (
+	AuthTTL          = 24 * time.Hour
+	RefreshTTL       = 30 * 24 * time.Hour
+	ActivationTTL    = 3 * 24 * time.Hour
+	PasswordResetTTL = 45 * time.Minute
+	TwoFactorTTL     = 5 * time.Minute
+)
+
+// APIKeyPrefix marks personal API keys so secret scanners can recognise them.
+const APIKeyPrefix = "mck_"
+
+// Permissions that can be granted to an API key. Session tokens carry all of
+// them implicitly.
+const (
+	PermissionTasksRead  = "tasks:read"
+	PermissionTasksWrite = "tasks:write"
+	PermissionUsersRead  = "users:read"
+)
+
+var APIKeyPermissions = []string{
+	PermissionTasksRead,
+	PermissionTasksWrite,
+	PermissionUsersRead,
+}
+
 func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userID,
@@ -26,14 +72,45 @@ func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 		Scope:  scope,
 	}
 
-	emptyBytes := make([]byte, 32)
-	_, err := rand.Read(emptyBytes)
+	plaintext, err := randomString(32)
 	if err != nil {
 		return nil, err
 	}
 
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes)
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Plaintext = plaintext
+	token.Hash = HashToken(token.Plaintext)
 	return token, nil
 }
+
+// GenerateAPIKey returns a new API key plaintext, the short prefix shown to the
+// user to identify it later, and the hash to store.
+func GenerateAPIKey() (plaintext string, displayPrefix string, hash []byte, err error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	plaintext = APIKeyPrefix + secret
+	return plaintext, plaintext[:len(APIKeyPrefix)+8], HashToken(plaintext), nil
+}
+
+// HashToken returns the digest stored in place of a token's plaintext.
+func HashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// NewFamily returns an identifier shared by the tokens issued for one sign-in.
+func NewFamily() (string, error) {
+	return randomString(16)
+}
+
+func randomString(n int) (string, error) {
+	emptyBytes := make([]byte, n)
+	_, err := rand.Read(emptyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes), nil
+}
@@ -4,11 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Envelope map[string]interface{}
@@ -26,6 +27,14 @@ func WriteJSON(w http.ResponseWriter, status int, data Envelope) error {
 	return nil
 }
 
+// WriteTooManyRequests responds 429 with a Retry-After header telling the
+// client how many seconds to wait.
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) error {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return WriteJSON(w, http.StatusTooManyRequests, Envelope{"error": "too many failed attempts, try again later"})
+}
+
 func ReadIDParam(r *http.Request) (int64, error) {
 	idParam := chi.URLParam(r, "id")
 	if idParam == "" {
@@ -46,6 +55,11 @@ func ReadIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-func HashPassword(password []byte) ([]byte, error) {
-	return bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+// ClientIP returns the address of the client that made the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }